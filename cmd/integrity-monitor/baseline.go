@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/notifier"
+	"integrity-monitor/pkg/models"
+)
+
+// runBaselineCommand manages signing and portable copies of the baseline
+func runBaselineCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor baseline keygen -private baseline.key -public baseline.pub
+  integrity-monitor baseline sign -key /media/offline/baseline.key
+  integrity-monitor baseline verify
+  integrity-monitor baseline export [-key baseline.key] [-m description] -o baseline.json.gz
+  integrity-monitor baseline import [-mode replace|merge] [-dry-run] baseline.json.gz`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("baseline "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	privatePath := fs.String("private", "baseline.key", "Where keygen writes the private key")
+	publicPath := fs.String("public", "baseline.pub", "Where keygen writes the public key")
+	keyPath := fs.String("key", "", "Private key used to sign the baseline")
+	output := fs.String("o", "", "Export destination (.json, or .json.gz for compressed)")
+	description := fs.String("m", "", "Description stored in the export")
+	mode := fs.String("mode", "replace", "Import mode: replace or merge")
+	dryRun := fs.Bool("dry-run", false, "Only show what the import would change")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "keygen":
+		if err := baseline.GenerateKey(*privatePath, *publicPath); err != nil {
+			log.Fatalf("Failed to generate key: %v", err)
+		}
+		fmt.Printf("Private key written to %s - keep it offline\n", *privatePath)
+		fmt.Printf("Public key written to %s - set baseline.public_key to its installed path\n", *publicPath)
+	case "sign":
+		if *keyPath == "" {
+			usage()
+		}
+		priv, err := baseline.LoadPrivateKey(*keyPath)
+		if err != nil {
+			log.Fatalf("Failed to load private key: %v", err)
+		}
+		// The daemon could change the baseline between reading it and
+		// storing the signature
+		_, storage, release := openStorageExclusive(*configPath, "baseline sign")
+		defer release()
+
+		sig, err := baseline.SignBaseline(storage, priv)
+		if err != nil {
+			log.Fatalf("Failed to sign baseline: %v", err)
+		}
+		fmt.Printf("Signed %d files, Merkle root %s, key %s\n", sig.FileCount, sig.MerkleRoot, sig.KeyID)
+	case "verify":
+		cfg, storage := openStorage(*configPath)
+		defer storage.Close()
+
+		verifier := newBaselineVerifier(cfg, storage)
+		if verifier == nil {
+			log.Fatalf("baseline.public_key is not configured")
+		}
+		sig, err := verifier.Verify(context.Background())
+		if err != nil {
+			log.Fatalf("Baseline verification FAILED: %v", err)
+		}
+		fmt.Printf("Baseline OK: %d files, Merkle root %s, signed %s by key %s\n",
+			sig.FileCount, sig.MerkleRoot, sig.SignedAt.Format("2006-01-02 15:04:05"), sig.KeyID)
+	case "export":
+		if *output == "" {
+			usage()
+		}
+		_, storage := openStorage(*configPath)
+		defer storage.Close()
+		exportBaseline(storage, *output, *keyPath, *description)
+	case "import":
+		if fs.NArg() != 1 || (*mode != "replace" && *mode != "merge") {
+			usage()
+		}
+		var cfg *config.Config
+		var storage *database.SQLiteStorage
+		if *dryRun {
+			cfg, storage = openStorage(*configPath)
+			defer storage.Close()
+		} else {
+			var release func()
+			cfg, storage, release = openStorageExclusive(*configPath, "baseline import")
+			defer release()
+		}
+		importBaseline(cfg, storage, fs.Arg(0), *mode == "replace", *dryRun)
+	default:
+		usage()
+	}
+}
+
+func exportBaseline(storage database.Storage, output, keyPath, description string) {
+	utilities, err := storage.GetAllUtilities()
+	if err != nil {
+		log.Fatalf("Failed to read baseline: %v", err)
+	}
+
+	doc := baseline.NewDocument(utilities, description)
+	if keyPath != "" {
+		priv, err := baseline.LoadPrivateKey(keyPath)
+		if err != nil {
+			log.Fatalf("Failed to load private key: %v", err)
+		}
+		doc.Sign(priv)
+	} else if sig, err := storage.GetBaselineSignature(); err == nil && sig != nil && sig.MerkleRoot == doc.MerkleRoot {
+		// The stored signature covers exactly this baseline
+		doc.Signature = &baseline.Signature{KeyID: sig.KeyID, Value: sig.Signature}
+	}
+
+	if err := baseline.WriteDocument(output, doc); err != nil {
+		log.Fatalf("Failed to write %s: %v", output, err)
+	}
+
+	signed := "unsigned"
+	if doc.Signature != nil {
+		signed = "signed by key " + doc.Signature.KeyID
+	}
+	fmt.Printf("Exported %d files to %s (Merkle root %s, %s)\n", len(doc.Files), output, doc.MerkleRoot, signed)
+}
+
+func importBaseline(cfg *config.Config, storage database.Storage, input string, replace, dryRun bool) {
+	doc, err := baseline.ReadDocument(input)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", input, err)
+	}
+	if err := doc.Validate(); err != nil {
+		log.Fatalf("Invalid baseline document: %v", err)
+	}
+
+	if cfg.Baseline.PublicKey != "" {
+		pub, err := baseline.LoadPublicKey(cfg.Baseline.PublicKey)
+		if err != nil {
+			log.Fatalf("Failed to load baseline public key: %v", err)
+		}
+		if err := doc.VerifySignature(pub); err != nil {
+			log.Fatalf("Refusing to import: %v", err)
+		}
+	}
+
+	current, err := storage.GetAllUtilities()
+	if err != nil {
+		log.Fatalf("Failed to read baseline: %v", err)
+	}
+
+	entries := doc.Utilities()
+	changes := baseline.Diff(current, entries)
+	if !replace {
+		// Merging never removes files
+		kept := changes[:0]
+		for _, change := range changes {
+			if change.Kind != models.ChangeRemoved {
+				kept = append(kept, change)
+			}
+		}
+		changes = kept
+	}
+
+	if dryRun {
+		printChanges(changes)
+		return
+	}
+
+	snap, err := storage.ImportBaseline(entries, replace, fmt.Sprintf("imported from %s (%s, %s)",
+		input, doc.Host, doc.CreatedAt.Format("2006-01-02 15:04:05")))
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	fmt.Printf("Imported %d files with %d changes as generation %d\n", len(entries), len(changes), snap.ID)
+
+	if replace && doc.Signature != nil {
+		// The active baseline now equals the document, so its signature applies
+		err := storage.SaveBaselineSignature(&models.BaselineSignature{
+			MerkleRoot: doc.MerkleRoot,
+			Signature:  doc.Signature.Value,
+			KeyID:      doc.Signature.KeyID,
+			FileCount:  len(doc.Files),
+			SignedAt:   doc.CreatedAt,
+		})
+		if err != nil {
+			log.Fatalf("Failed to store baseline signature: %v", err)
+		}
+	} else if cfg.Baseline.PublicKey != "" {
+		fmt.Println("The merged baseline must be signed again with 'integrity-monitor baseline sign'")
+	}
+}
+
+// newBaselineVerifier returns nil when no public key is configured
+func newBaselineVerifier(cfg *config.Config, storage database.Storage) *baseline.Verifier {
+	if cfg.Baseline.PublicKey == "" {
+		return nil
+	}
+
+	pub, err := baseline.LoadPublicKey(cfg.Baseline.PublicKey)
+	if err != nil {
+		log.Fatalf("Failed to load baseline public key: %v", err)
+	}
+	return baseline.NewVerifier(storage, pub)
+}
+
+// requireTrustedBaseline refuses to start when the baseline signature does
+// not verify
+func requireTrustedBaseline(verifier *baseline.Verifier) {
+	if verifier == nil {
+		return
+	}
+
+	sig, err := verifier.Verify(context.Background())
+	if err != nil {
+		if errors.Is(err, baseline.ErrUnsigned) {
+			log.Fatalf("Refusing to use baseline: %v (run 'integrity-monitor baseline sign')", err)
+		}
+		log.Fatalf("Refusing to use baseline: %v", err)
+	}
+	log.Printf("Baseline signature verified (%d files, key %s)", sig.FileCount, sig.KeyID)
+}
+
+// checkBaseline verifies the baseline before it is used and raises a critical
+// alert when it can no longer be trusted
+func checkBaseline(ctx context.Context, cfg *config.Config, storage database.Storage, verifier *baseline.Verifier, notif notifier.Notifier) bool {
+	if verifier == nil {
+		return true
+	}
+
+	sig, err := verifier.Verify(ctx)
+	if err == nil {
+		return true
+	}
+
+	log.Printf("SECURITY: baseline verification failed, skipping checks: %v", err)
+
+	alert := &models.Alert{
+		Kind:        models.AlertBaselineUntrusted,
+		UtilityPath: cfg.Database.Path,
+		Message:     err.Error(),
+		DetectedAt:  time.Now(),
+		Severity:    "critical",
+	}
+	if sig != nil {
+		alert.OldChecksum = sig.MerkleRoot
+	}
+	if err := storage.SaveAlert(alert); err != nil {
+		log.Printf("Failed to save alert: %v", err)
+	}
+	notif.SendAlert(ctx, alert)
+
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/rootfs"
+)
+
+// runConfigCommand inspects configuration files
+func runConfigCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor config check [-config path] [-root dir]
+  integrity-monitor config show [-config path] [-effective]`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	rootDir := fs.String("root", "", "Root directory the monitored paths are looked up in")
+	effective := fs.Bool("effective", false, "Print the merged configuration with the source of each value")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "check":
+		root, err := rootfs.New(*rootDir)
+		if err != nil {
+			log.Fatalf("Invalid root directory: %v", err)
+		}
+		problems := checkConfig(*configPath, root)
+		if len(problems) > 0 {
+			fmt.Printf("%s: found %d problem(s)\n", *configPath, len(problems))
+			for _, p := range problems {
+				fmt.Printf("  %s\n", p)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", *configPath)
+	case "show":
+		if *effective {
+			showEffectiveConfig(*configPath)
+		} else {
+			showConfigSources(*configPath)
+		}
+	default:
+		usage()
+	}
+}
+
+// checkConfig lists everything wrong with the file at configPath, including
+// message templates and notifier settings that are otherwise only noticed
+// when the daemon builds its channels
+func checkConfig(configPath string, root rootfs.Root) []string {
+	files, err := config.Files(configPath)
+	if err != nil {
+		return problemsOf(err)
+	}
+	if len(files) == 0 {
+		return []string{fmt.Sprintf("neither %s nor %s/*.yaml exist", configPath, config.DropInDir(configPath))}
+	}
+
+	cfg, err := config.Load(configPath)
+	if cfg == nil {
+		return problemsOf(err)
+	}
+
+	problems := append(problemsOf(err), problemsOf(cfg.Validate(root))...)
+
+	// The locale itself is checked by Validate
+	messages, err := notifier.NewMessages("", cfg.Notifiers.Templates)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("notifiers.templates: %v", err))
+		messages = notifier.DefaultMessages()
+	}
+	for i, wh := range cfg.Notifiers.Webhooks {
+		if wh.URL == "" {
+			continue // already reported
+		}
+		_, err := notifier.NewWebhookNotifier(notifier.WebhookOptions{
+			URL:          wh.URL,
+			Method:       wh.Method,
+			Headers:      wh.Headers,
+			BodyTemplate: wh.BodyTemplate,
+			Messages:     messages,
+		})
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("notifiers.webhooks[%d]: %v", i, err))
+		}
+	}
+
+	return problems
+}
+
+// showConfigSources lists what makes up the configuration, in the order
+// it is applied
+func showConfigSources(configPath string) {
+	files, err := config.Files(configPath)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Println("defaults")
+	for _, file := range files {
+		fmt.Println(file)
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, config.EnvPrefix) {
+			name, _, _ := strings.Cut(kv, "=")
+			env = append(env, name)
+		}
+	}
+	sort.Strings(env)
+	for _, name := range env {
+		fmt.Printf("env %s\n", name)
+	}
+}
+
+// showEffectiveConfig prints the merged configuration as YAML, each value
+// commented with the file, line or variable that set it
+func showEffectiveConfig(configPath string) {
+	cfg, sources, err := config.LoadWithSources(configPath)
+	if err != nil {
+		if cfg == nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		log.Printf("Warning: %v", err)
+	}
+	out, err := cfg.Annotated(sources)
+	if err != nil {
+		log.Fatalf("Failed to render configuration: %v", err)
+	}
+	os.Stdout.Write(out)
+}
+
+// problemsOf splits a validation error into its individual problems
+func problemsOf(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Problems
+	}
+	return []string{err.Error()}
+}
+
+// validateConfig stops the process when cfg cannot be run with
+func validateConfig(cfg *config.Config, root rootfs.Root) {
+	if problems := problemsOf(cfg.Validate(root)); len(problems) > 0 {
+		log.Fatalf("Invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"integrity-monitor/internal/control"
+	"integrity-monitor/pkg/models"
+)
+
+// runCtlCommand talks to the running daemon over its control socket
+func runCtlCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor ctl status
+  integrity-monitor ctl scan [-wait]
+  integrity-monitor ctl pause
+  integrity-monitor ctl resume
+  integrity-monitor ctl reload
+  integrity-monitor ctl alerts [-n 20]`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("ctl "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	wait := fs.Bool("wait", false, "Wait for the scan to finish")
+	limit := fs.Int("n", 20, "Number of alerts to show")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	client, err := control.Dial(cfg.Control.Socket)
+	if err != nil && !(args[0] == "alerts" && errors.Is(err, control.ErrNotRunning)) {
+		log.Fatalf("Failed to reach the daemon at %s: %v", cfg.Control.Socket, err)
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := client.Status()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printStatus(status)
+	case "scan":
+		result, err := client.Scan(*wait)
+		if err != nil {
+			log.Fatalf("Failed to start scan: %v", err)
+		}
+		if result == nil {
+			fmt.Println("Scan started")
+			return
+		}
+		printScanResult(result)
+		if result.Error != "" || result.Alerts > 0 {
+			os.Exit(1)
+		}
+	case "pause":
+		if _, err := client.Pause(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println("Monitoring paused: periodic scans and watcher events are ignored until 'integrity-monitor ctl resume'")
+	case "resume":
+		if _, err := client.Resume(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println("Monitoring resumed; run 'integrity-monitor ctl scan' to check what changed meanwhile")
+	case "reload":
+		if _, err := client.Reload(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println("Configuration reloaded")
+	case "alerts":
+		var alerts []*models.Alert
+		if client != nil {
+			alerts, err = client.Alerts(*limit)
+		} else {
+			_, storage := openStorage(*configPath)
+			defer storage.Close()
+			alerts, err = storage.GetRecentAlerts(*limit)
+		}
+		if err != nil {
+			log.Fatalf("Failed to read alerts: %v", err)
+		}
+		printAlerts(alerts)
+	default:
+		usage()
+	}
+}
+
+func printStatus(s *control.Status) {
+	state := "monitoring"
+	switch {
+	case s.Paused:
+		state = "PAUSED"
+	case s.Scanning:
+		state = "scanning"
+	}
+
+	fmt.Printf("Integrity Monitor v%s, pid %d, up since %s\n", s.Version, s.PID, s.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("State:          %s\n", state)
+	fmt.Printf("Root:           %s\n", s.Root)
+	fmt.Printf("Paths:          %s\n", strings.Join(s.MonitoredPaths, ", "))
+	fmt.Printf("Watcher:        %t\n", s.Watcher)
+	for _, sched := range s.Schedules {
+		next := "never"
+		if !sched.Next.IsZero() {
+			next = sched.Next.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("Schedule:       %s: %s scan %s", sched.Name, sched.Mode, sched.Spec)
+		if sched.Jitter != "" {
+			fmt.Printf(" (jitter %s)", sched.Jitter)
+		}
+		fmt.Printf(", next %s\n", next)
+	}
+	if s.LastScan != nil {
+		fmt.Print("Last scan:      ")
+		printScanResult(s.LastScan)
+	} else {
+		fmt.Println("Last scan:      none yet")
+	}
+
+	names := make([]string, 0, len(s.Health.Components))
+	for name := range s.Health.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := s.Health.Components[name]
+		state := "ok"
+		switch {
+		case !c.Healthy:
+			state = "HUNG"
+		case !c.Ready:
+			state = "not ready"
+		}
+		fmt.Printf("%-15s %s, last heartbeat %s", name+":", state, c.LastBeat.Format("15:04:05"))
+		if c.Message != "" {
+			fmt.Printf(" (%s)", c.Message)
+		}
+		fmt.Println()
+	}
+}
+
+func printScanResult(r *control.ScanResult) {
+	if r.Error != "" {
+		fmt.Printf("%s %s scan FAILED: %s\n", r.StartedAt.Format("2006-01-02 15:04:05"), r.Mode, r.Error)
+		return
+	}
+	fmt.Printf("%s %s scan, %d files in %s, %d alerts, %d errors\n", r.StartedAt.Format("2006-01-02 15:04:05"), r.Mode,
+		r.Files, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), r.Alerts, r.Errors)
+}
+
+func printAlerts(alerts []*models.Alert) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDETECTED\tSEVERITY\tPATH")
+	for _, a := range alerts {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", a.ID, a.DetectedAt.Format("2006-01-02 15:04:05"), a.Severity, a.UtilityPath)
+	}
+	w.Flush()
+}
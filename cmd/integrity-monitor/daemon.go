@@ -0,0 +1,704 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/checksum"
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/control"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/health"
+	"integrity-monitor/internal/metrics"
+	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/rootfs"
+	"integrity-monitor/internal/scanner"
+	"integrity-monitor/internal/schedule"
+	"integrity-monitor/internal/selfcheck"
+	"integrity-monitor/internal/systemd"
+	"integrity-monitor/internal/watcher"
+	"integrity-monitor/pkg/models"
+)
+
+// daemon is the running monitor as seen through the control socket
+type daemon struct {
+	*maintenanceService
+
+	// ctx is cancelled when the daemon shuts down; stop cancels it early
+	// with the error that made the daemon give up
+	ctx  context.Context
+	stop context.CancelCauseFunc
+	// loops tracks the goroutines that must finish before the database
+	// is closed
+	loops sync.WaitGroup
+
+	configPath string
+	scan       *scanner.Scanner
+	comp       *checksum.Comparator
+	dispatcher *notifier.Dispatcher
+	verifier   *baseline.Verifier
+	self       *selfChecker // nil unless self_check.manifest is set
+	startedAt  time.Time
+	health     *health.Monitor
+	scanHealth *health.Component
+
+	paused atomic.Bool
+	// untrusted is set once a failed baseline verification was alerted
+	untrusted atomic.Bool
+	trigger   chan chan *control.ScanResult // nil reply: don't wait
+	schedules chan []*scheduledScan         // new scan plan after a reload
+
+	mu       sync.Mutex
+	cfg      *config.Config
+	plan     []control.ScheduleStatus
+	scanning bool
+	lastScan *control.ScanResult
+
+	// reloadMu serializes reloads, which start and stop the watcher
+	reloadMu sync.Mutex
+	watcher  *watcher.Watcher
+}
+
+func newDaemon(ctx context.Context, cfg *config.Config, configPath string, storage database.Storage, root rootfs.Root, scan *scanner.Scanner, comp *checksum.Comparator, dispatcher *notifier.Dispatcher, verifier *baseline.Verifier) *daemon {
+	ctx, stop := context.WithCancelCause(ctx)
+	monitor := health.NewMonitor(time.Duration(cfg.Health.StallTimeout))
+	return &daemon{
+		maintenanceService: &maintenanceService{storage: storage, root: root},
+		ctx:                ctx,
+		stop:               stop,
+		configPath:         configPath,
+		scan:               scan,
+		comp:               comp,
+		dispatcher:         dispatcher,
+		verifier:           verifier,
+		startedAt:          time.Now(),
+		health:             monitor,
+		scanHealth:         monitor.Register("scanner"),
+		trigger:            make(chan chan *control.ScanResult, 1),
+		schedules:          make(chan []*scheduledScan, 1),
+		cfg:                cfg,
+	}
+}
+
+// scheduledScan is one entry of the scan plan
+type scheduledScan struct {
+	config.ScheduleConfig
+	schedule schedule.Schedule
+	next     time.Time
+}
+
+// buildSchedules turns the configured schedules into a scan plan
+func buildSchedules(cfg *config.Config) []*scheduledScan {
+	var plan []*scheduledScan
+	for _, s := range cfg.ScanSchedules() {
+		sched, err := s.Schedule()
+		if err != nil {
+			log.Printf("Warning: skipping schedule %s: %v", s.Name, err)
+			continue
+		}
+		plan = append(plan, &scheduledScan{ScheduleConfig: s, schedule: sched})
+	}
+	return plan
+}
+
+// runScans performs the scheduled scans and those requested over the
+// control socket, one at a time
+func (d *daemon) runScans() {
+	plan := buildSchedules(d.config())
+	d.planScans(plan, time.Now(), true)
+	timer := time.NewTimer(untilNextScan(plan))
+	defer timer.Stop()
+	idle := time.NewTicker(10 * time.Second)
+	defer idle.Stop()
+
+	d.updateReadiness()
+	for {
+		var reply chan *control.ScanResult
+		mode := config.ScanDeep
+		select {
+		case <-idle.C:
+			d.scanHealth.Beat()
+			continue
+		case plan = <-d.schedules:
+			d.planScans(plan, time.Now(), true)
+			resetTimer(timer, untilNextScan(plan))
+			continue
+		case <-timer.C:
+			mode = d.planScans(plan, time.Now(), false)
+			resetTimer(timer, untilNextScan(plan))
+			if mode == "" {
+				continue
+			}
+			if d.paused.Load() {
+				log.Printf("Monitoring is paused, skipping %s scan", mode)
+				continue
+			}
+		case reply = <-d.trigger:
+		case <-d.ctx.Done():
+			return
+		}
+
+		result := d.fullScan(mode)
+		if reply != nil {
+			reply <- result
+		}
+	}
+}
+
+// planScans sets when each schedule fires next, all of them or only those
+// that are due, and returns the mode of the scan due now: deep if any due
+// schedule asks for it, "" if none is due
+func (d *daemon) planScans(plan []*scheduledScan, now time.Time, all bool) string {
+	mode := ""
+	for _, s := range plan {
+		if !all && (s.next.IsZero() || s.next.After(now)) {
+			continue
+		}
+		if !all && mode != config.ScanDeep {
+			mode = s.Mode
+		}
+		s.next = s.schedule.Next(now)
+		if !s.next.IsZero() {
+			s.next = s.next.Add(schedule.Jitter(time.Duration(s.Jitter)))
+		}
+	}
+
+	status := make([]control.ScheduleStatus, len(plan))
+	for i, s := range plan {
+		spec := s.Cron
+		if spec == "" {
+			spec = "every " + s.Every.String()
+		}
+		status[i] = control.ScheduleStatus{Name: s.Name, Spec: spec, Mode: s.Mode, Next: s.next}
+		if s.Jitter > 0 {
+			status[i].Jitter = s.Jitter.String()
+		}
+	}
+	d.mu.Lock()
+	d.plan = status
+	d.mu.Unlock()
+	return mode
+}
+
+// untilNextScan returns the time left until the earliest scheduled scan
+func untilNextScan(plan []*scheduledScan) time.Duration {
+	var next time.Time
+	for _, s := range plan {
+		if !s.next.IsZero() && (next.IsZero() || s.next.Before(next)) {
+			next = s.next
+		}
+	}
+	if next.IsZero() {
+		// Nothing scheduled; wake up now and then anyway
+		return 24 * time.Hour
+	}
+	return time.Until(next)
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// fullScan checks every monitored file; a quick scan hashes only files
+// whose size or modification time changed
+func (d *daemon) fullScan(mode string) *control.ScanResult {
+	cfg := d.config()
+	result := &control.ScanResult{Mode: mode, StartedAt: time.Now()}
+
+	d.mu.Lock()
+	d.scanning = true
+	d.mu.Unlock()
+	d.notifyStatus("Scanning...")
+	defer func() {
+		result.FinishedAt = time.Now()
+		recordScanMetrics(result)
+		d.mu.Lock()
+		d.scanning = false
+		d.lastScan = result
+		d.mu.Unlock()
+		d.updateReadiness()
+	}()
+
+	// Hashing, walking and verifying the baseline beat the scanner as they
+	// go, so a large file or baseline is not taken for a stall
+	ctx := health.WithBeat(d.ctx, d.scanHealth.Beat)
+
+	if !d.baselineTrusted(ctx, cfg, true) {
+		result.Error = "baseline verification failed"
+		return result
+	}
+
+	log.Printf("Starting %s scan...", mode)
+
+	utilities, err := d.scan.ScanAll(ctx)
+	if err != nil {
+		log.Printf("Scan error: %v", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Files = len(utilities)
+
+	d.reloadMaintenanceWindows()
+	d.dispatcher.BeginBatch()
+	for _, util := range utilities {
+		d.scanHealth.Beat()
+		check := d.comp.CheckFile
+		if mode == config.ScanQuick {
+			check = d.comp.CheckFileQuick
+		}
+		alert, err := check(ctx, util)
+		if d.ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("Error checking %s: %v", util, err)
+			result.Errors++
+			continue
+		}
+
+		if alert != nil {
+			result.Alerts++
+			d.dispatcher.SendAlert(d.ctx, alert)
+		}
+	}
+	if err := d.dispatcher.EndBatch(d.ctx); err != nil {
+		log.Printf("Failed to deliver alert digest: %v", err)
+	}
+
+	if d.ctx.Err() != nil {
+		log.Printf("Scan (%s) interrupted by shutdown", mode)
+		result.Error = "interrupted by shutdown"
+		return result
+	}
+
+	if result.Alerts > 0 {
+		log.Printf("Scan complete (%s): %d alerts generated", mode, result.Alerts)
+	} else {
+		log.Printf("Scan complete (%s): No modifications detected", mode)
+	}
+	return result
+}
+
+// StartMaintenance opens a window that the comparator honours at once
+func (d *daemon) StartMaintenance(req control.StartMaintenanceRequest) (*models.MaintenanceWindow, error) {
+	w, err := d.maintenanceService.StartMaintenance(req)
+	if err == nil {
+		d.reloadMaintenanceWindows()
+	}
+	return w, err
+}
+
+// EndMaintenance ends a window, which the comparator stops honouring at once
+func (d *daemon) EndMaintenance(id int64, req control.EndMaintenanceRequest) (*control.EndMaintenanceResult, error) {
+	result, err := d.maintenanceService.EndMaintenance(id, req)
+	d.reloadMaintenanceWindows()
+	return result, err
+}
+
+func (d *daemon) reloadMaintenanceWindows() {
+	if err := d.comp.LoadMaintenanceWindows(); err != nil {
+		log.Printf("Failed to load maintenance windows: %v", err)
+	}
+}
+
+// expireMaintenance closes the windows that ran out, reporting each like
+// "maintenance end" would, and picks up windows opened without going
+// through the daemon, as when the control socket is disabled
+func (d *daemon) expireMaintenance() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := d.expireWindows(now)
+			if err != nil {
+				log.Printf("Failed to expire maintenance windows: %v", err)
+			}
+			for _, detail := range expired {
+				d.reportExpired(detail)
+			}
+			d.reloadMaintenanceWindows()
+		}
+	}
+}
+
+// reportExpired logs the summary of a window that expired and alerts that
+// its changes were not approved
+func (d *daemon) reportExpired(detail *control.MaintenanceDetail) {
+	w := detail.Window
+	log.Printf("Maintenance window %d expired without being ended", w.ID)
+	for _, line := range maintenanceSummary(detail) {
+		log.Printf("%s", line)
+	}
+
+	paths := w.Paths
+	if len(paths) == 0 {
+		for _, c := range detail.Changes {
+			paths = append(paths, c.Path)
+		}
+	}
+	alert := &models.Alert{
+		Kind:        models.AlertMaintenanceExpired,
+		UtilityPath: strings.Join(paths, ", "),
+		Message: fmt.Sprintf("maintenance window %d (%s) expired at %s with %d changes not approved",
+			w.ID, w.Reason, w.EndsAt.Format("2006-01-02 15:04:05"), len(detail.Changes)),
+		DetectedAt: time.Now(),
+		Severity:   "medium",
+	}
+	if err := d.storage.SaveAlert(alert); err != nil {
+		log.Printf("Failed to save alert: %v", err)
+	}
+	d.dispatcher.SendAlert(d.ctx, alert)
+}
+
+// updateReadiness reports the scanner ready unless monitoring is paused or
+// the last scan failed, and tells systemd what the daemon is doing
+func (d *daemon) updateReadiness() {
+	d.mu.Lock()
+	last := d.lastScan
+	d.mu.Unlock()
+
+	var status string
+	switch {
+	case d.paused.Load():
+		status = "Paused through the control socket"
+		d.scanHealth.SetReady(false, "monitoring is paused")
+	case last != nil && last.Error != "":
+		status = "Last scan failed: " + last.Error
+		d.scanHealth.SetReady(false, status)
+	case last != nil:
+		status = fmt.Sprintf("Last scan %s: %d files, %d alerts", last.FinishedAt.Format("15:04:05"), last.Files, last.Alerts)
+		d.scanHealth.SetReady(true, status)
+	default:
+		status = "Monitoring, waiting for the first periodic scan"
+		d.scanHealth.SetReady(true, "waiting for the first scan")
+	}
+	d.notifyStatus(status)
+}
+
+// notifyStatus shows status in "systemctl status"
+func (d *daemon) notifyStatus(status string) {
+	if _, err := systemd.Notify("STATUS=" + status); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
+func recordScanMetrics(result *control.ScanResult) {
+	metrics.ScanDuration.Observe(result.FinishedAt.Sub(result.StartedAt).Seconds())
+	if result.Error != "" {
+		metrics.Scans.Inc("failed")
+		return
+	}
+	metrics.Scans.Inc("ok")
+	metrics.LastSuccessfulScan.Set(float64(result.FinishedAt.Unix()))
+	metrics.FilesByState.Set(float64(result.Files-result.Alerts-result.Errors), metrics.StateOK)
+	metrics.FilesByState.Set(float64(result.Alerts), metrics.StateModified)
+	metrics.FilesByState.Set(float64(result.Errors), metrics.StateError)
+}
+
+// watchHandler checks files reported by the watcher unless paused or the
+// baseline they would be compared with fails verification
+func (d *daemon) watchHandler() watcher.EventHandler {
+	handler := watcher.CreateFileChangeHandler(d.comp, d.dispatcher, d.root)
+	return func(ctx context.Context, path string, event fsnotify.Op) error {
+		if d.paused.Load() {
+			return nil
+		}
+		if !d.baselineTrusted(ctx, d.config(), false) {
+			log.Printf("Ignoring change in %s: the baseline is not trusted", path)
+			return nil
+		}
+		return handler(ctx, path, event)
+	}
+}
+
+// baselineTrusted verifies the baseline before files are checked against
+// it. Every scan alerts a failure; watcher events only alert the first one
+// until the baseline verifies again, so a burst of events raises one alert.
+func (d *daemon) baselineTrusted(ctx context.Context, cfg *config.Config, scan bool) bool {
+	if !scan && d.untrusted.Load() {
+		if _, err := d.verifier.Verify(ctx); err != nil {
+			return false
+		}
+		d.untrusted.Store(false)
+		return true
+	}
+
+	trusted := checkBaseline(ctx, cfg, d.storage, d.verifier, d.dispatcher)
+	d.untrusted.Store(!trusted)
+	return trusted
+}
+
+// startWatcher watches paths for changes between scans
+func (d *daemon) startWatcher(paths []string) error {
+	w, err := watcher.NewWatcher(paths, d.root, d.watchHandler())
+	if err != nil {
+		return err
+	}
+
+	watchHealth := d.health.Register("watcher")
+	w.SetHeartbeat(watchHealth.Beat)
+	if w.Watching() > 0 {
+		watchHealth.SetReady(true, fmt.Sprintf("watching %d directories", w.Watching()))
+	} else {
+		watchHealth.SetReady(false, "no monitored directory could be watched")
+	}
+
+	d.spawn(func() {
+		if err := w.Start(d.ctx); err != nil {
+			log.Printf("Watcher error: %v", err)
+			d.stop(fmt.Errorf("watcher failed: %w", err))
+		}
+	})
+	d.watcher = w
+	return nil
+}
+
+// spawn runs loop in a goroutine that shutdown waits for
+func (d *daemon) spawn(loop func()) {
+	d.loops.Add(1)
+	go func() {
+		defer d.loops.Done()
+		loop()
+	}()
+}
+
+// shutdown cancels the daemon's context and waits up to shutdown_timeout
+// for its loops to finish, then spends what is left of that time
+// delivering alerts still queued in the outbox. Alerts it cannot deliver
+// stay queued for the next start.
+func (d *daemon) shutdown() {
+	d.stop(context.Canceled)
+
+	timeout := time.Duration(d.config().ShutdownTimeout)
+	deadline := time.Now().Add(timeout)
+	finished := make(chan struct{})
+	go func() {
+		d.loops.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		log.Printf("Warning: scan, watcher or delivery still running after %s, exiting anyway", timeout)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := d.dispatcher.Flush(ctx); err != nil {
+		log.Printf("Alerts left undelivered stay in the outbox until the next start: %v", err)
+	}
+}
+
+func (d *daemon) stopWatcher() {
+	if d.watcher == nil {
+		return
+	}
+	d.watcher.Close()
+	d.watcher = nil
+	d.health.Unregister("watcher")
+}
+
+func (d *daemon) config() *config.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg
+}
+
+func (d *daemon) Status() control.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return control.Status{
+		PID:            os.Getpid(),
+		Version:        version,
+		StartedAt:      d.startedAt,
+		Root:           d.root.String(),
+		MonitoredPaths: d.cfg.MonitoredPaths,
+		Schedules:      d.plan,
+		Watcher:        d.cfg.EnableWatcher,
+		Paused:         d.paused.Load(),
+		Scanning:       d.scanning,
+		LastScan:       d.lastScan,
+		Health:         d.health.Check(),
+	}
+}
+
+func (d *daemon) Scan(wait bool) (*control.ScanResult, error) {
+	var reply chan *control.ScanResult
+	if wait {
+		reply = make(chan *control.ScanResult, 1)
+	}
+
+	select {
+	case d.trigger <- reply:
+	default:
+		return nil, errors.New("a scan is already pending")
+	}
+	if !wait {
+		return nil, nil
+	}
+	select {
+	case result := <-reply:
+		return result, nil
+	case <-d.ctx.Done():
+		return nil, errors.New("the daemon is shutting down")
+	}
+}
+
+func (d *daemon) Pause() error {
+	if d.paused.Swap(true) {
+		return errors.New("monitoring is already paused")
+	}
+	metrics.Paused.Set(1)
+	d.updateReadiness()
+	return nil
+}
+
+func (d *daemon) Resume() error {
+	if !d.paused.Swap(false) {
+		return errors.New("monitoring is not paused")
+	}
+	metrics.Paused.Set(0)
+	d.updateReadiness()
+	return nil
+}
+
+// Reload re-reads the configuration and applies monitored paths, the scan
+// interval, the watcher and notification channels without a restart.
+// Other settings are kept until the next restart. An invalid file is
+// rejected and the running configuration stays in effect.
+func (d *daemon) Reload() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	systemd.Notify("RELOADING=1")
+	defer systemd.Notify("READY=1")
+
+	cfg, err := loadConfig(d.configPath)
+	if err == nil {
+		err = cfg.Validate(d.root)
+	}
+	if err != nil {
+		log.Printf("Configuration reload rejected, keeping the running configuration: %v", err)
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// A configuration the signed manifest does not cover is not applied:
+	// re-signing it is what authorizes the change
+	if d.self != nil {
+		d.self.load(d.ctx)
+		for _, m := range d.self.check(d.ctx) {
+			if m.Kind == selfcheck.KindConfig {
+				log.Printf("Configuration reload rejected, keeping the running configuration: %s does not match %s",
+					m.Path, cfg.SelfCheck.Manifest)
+				return fmt.Errorf("%s does not match the self-check manifest; sign it with 'integrity-monitor self sign'", m.Path)
+			}
+		}
+	}
+
+	old := d.config()
+	running := *old
+	var applied []string
+
+	if !reflect.DeepEqual(old.MonitoredPaths, cfg.MonitoredPaths) {
+		running.MonitoredPaths = cfg.MonitoredPaths
+		d.scan.SetPaths(cfg.MonitoredPaths)
+		if d.watcher != nil && cfg.EnableWatcher {
+			d.watcher.SetPaths(cfg.MonitoredPaths)
+		}
+		applied = append(applied, "monitored_paths")
+	}
+
+	if old.EnableWatcher != cfg.EnableWatcher {
+		running.EnableWatcher = cfg.EnableWatcher
+		if cfg.EnableWatcher {
+			if err := d.startWatcher(cfg.MonitoredPaths); err != nil {
+				log.Printf("Failed to start watcher: %v", err)
+				running.EnableWatcher = false
+			}
+		} else {
+			d.stopWatcher()
+		}
+		applied = append(applied, "enable_watcher")
+	}
+
+	if old.ShutdownTimeout != cfg.ShutdownTimeout {
+		running.ShutdownTimeout = cfg.ShutdownTimeout
+		applied = append(applied, "shutdown_timeout")
+	}
+
+	if !reflect.DeepEqual(old.ScanSchedules(), cfg.ScanSchedules()) {
+		running.ScanInterval = cfg.ScanInterval
+		running.ScanJitter = cfg.ScanJitter
+		running.Schedules = cfg.Schedules
+		select {
+		case <-d.schedules: // superseded before the scan loop picked it up
+		default:
+		}
+		d.schedules <- buildSchedules(cfg)
+		applied = append(applied, "schedules")
+	}
+
+	if old.LogFile != cfg.LogFile || !reflect.DeepEqual(old.Notifiers, cfg.Notifiers) {
+		running.LogFile = cfg.LogFile
+		running.Notifiers = cfg.Notifiers
+		d.dispatcher.SetChannels(buildChannels(cfg))
+		applied = append(applied, "notifiers")
+	}
+
+	// Settings bound to open files, sockets and goroutines
+	for _, setting := range []struct {
+		name     string
+		old, new any
+	}{
+		{"database", old.Database, cfg.Database},
+		{"outbox", old.Outbox, cfg.Outbox},
+		{"baseline", old.Baseline, cfg.Baseline},
+		{"alert_chain", old.AlertChain, cfg.AlertChain},
+		{"packages", old.Packages, cfg.Packages},
+		{"control", old.Control, cfg.Control},
+		{"metrics", old.Metrics, cfg.Metrics},
+		{"health", old.Health, cfg.Health},
+		{"pid_file", old.PIDFile, cfg.PIDFile},
+		{"self_check", old.SelfCheck, cfg.SelfCheck},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			log.Printf("Warning: %s changed in %s; restart the daemon to apply it", setting.name, d.configPath)
+		}
+	}
+
+	d.mu.Lock()
+	d.cfg = &running
+	d.mu.Unlock()
+
+	if len(applied) == 0 {
+		log.Printf("Configuration reloaded from %s: nothing to apply", d.configPath)
+	} else {
+		log.Printf("Configuration reloaded from %s: applied %s", d.configPath, strings.Join(applied, ", "))
+	}
+	return nil
+}
+
+func (d *daemon) Alerts(limit int) ([]*models.Alert, error) {
+	return d.storage.GetRecentAlerts(limit)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"integrity-monitor/internal/database"
+)
+
+// runDBCommand inspects and upgrades the database schema
+func runDBCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: integrity-monitor db <version|migrate> [-config path]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("db "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Migrating under the daemon would change the schema it is using
+	if args[0] == "migrate" {
+		defer lockDatabase(cfg.Database.Path, "db migrate").Release()
+	}
+
+	// Open without migrating so the current state can be reported; version
+	// only reads and may run next to the daemon
+	open := database.OpenSQLiteStorage
+	if args[0] == "version" {
+		open = database.OpenSQLiteStorageReadOnly
+	}
+	storage, err := open(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer storage.Close()
+
+	switch args[0] {
+	case "version":
+		showSchemaVersion(storage)
+	case "migrate":
+		migrateSchema(storage)
+	default:
+		usage()
+	}
+}
+
+func showSchemaVersion(storage *database.SQLiteStorage) {
+	current, err := storage.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+	latest, err := database.LatestSchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+
+	fmt.Printf("Schema version: %d (binary supports %d)\n", current, latest)
+
+	pending, err := storage.PendingMigrations()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	for _, m := range pending {
+		fmt.Printf("Pending: %04d_%s\n", m.Version, m.Name)
+	}
+}
+
+func migrateSchema(storage *database.SQLiteStorage) {
+	applied, err := storage.Migrate()
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("Schema is up to date")
+		return
+	}
+
+	version, _ := storage.SchemaVersion()
+	fmt.Printf("Applied %d migrations, schema version is now %d\n", len(applied), version)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/checksum"
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/image"
+	"integrity-monitor/internal/rootfs"
+	"integrity-monitor/internal/scanner"
+	"integrity-monitor/pkg/models"
+)
+
+// runImageCommand baselines and verifies container images without running them
+func runImageCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor image baseline [-ref name] [-paths /bin,/usr/bin] [-key baseline.key] [-m description] -o baseline.json.gz <image>
+  integrity-monitor image verify [-ref name] [-paths /bin,/usr/bin] -baseline baseline.json.gz <image>
+
+<image> is a "docker save" tarball or an OCI image layout directory.`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("image "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	ref := fs.String("ref", "", "Image to use when the archive holds several (tag or OCI ref name)")
+	paths := fs.String("paths", "", "Comma-separated directories to scan inside the image (default: monitored_paths)")
+	keyPath := fs.String("key", "", "Private key used to sign the baseline")
+	output := fs.String("o", "", "Baseline destination (.json, or .json.gz for compressed)")
+	description := fs.String("m", "", "Description stored in the baseline")
+	baselinePath := fs.String("baseline", "", "Baseline to verify the image against")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	monitored := cfg.MonitoredPaths
+	if *paths != "" {
+		monitored = strings.Split(*paths, ",")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	switch args[0] {
+	case "baseline":
+		if *output == "" {
+			usage()
+		}
+		baselineImage(ctx, fs.Arg(0), *ref, monitored, *output, *keyPath, *description)
+	case "verify":
+		if *baselinePath == "" {
+			usage()
+		}
+		if !verifyImage(ctx, cfg, fs.Arg(0), *ref, monitored, *baselinePath) {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+// imageWorkspace is a flattened image with a scratch baseline database
+type imageWorkspace struct {
+	dir     string
+	ref     string
+	storage *database.SQLiteStorage
+	scan    *scanner.Scanner
+	comp    *checksum.Comparator
+}
+
+func openImageWorkspace(imagePath, ref string, monitored []string) *imageWorkspace {
+	img, err := image.Open(imagePath, ref)
+	if err != nil {
+		log.Fatalf("Failed to open image: %v", err)
+	}
+	defer img.Close()
+
+	dir, err := os.MkdirTemp("", "integrity-monitor-image-")
+	if err != nil {
+		log.Fatalf("Failed to create work directory: %v", err)
+	}
+	ws := &imageWorkspace{dir: dir, ref: img.Ref}
+	if ws.ref == "" {
+		ws.ref = imagePath
+	}
+
+	rootDir := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootDir, 0700); err != nil {
+		ws.fatalf("Failed to create work directory: %v", err)
+	}
+	log.Printf("Flattening %d layers of %s", len(img.Layers), imagePath)
+	if err := img.Flatten(rootDir); err != nil {
+		ws.fatalf("Failed to flatten image: %v", err)
+	}
+
+	root, err := rootfs.New(rootDir)
+	if err != nil {
+		ws.fatalf("Failed to open image filesystem: %v", err)
+	}
+	ws.storage, err = database.NewMigratedSQLiteStorage(filepath.Join(dir, "baseline.db"))
+	if err != nil {
+		ws.fatalf("Failed to create work database: %v", err)
+	}
+	ws.scan = scanner.NewScanner(monitored, root)
+	ws.comp = checksum.NewComparator(ws.storage, root)
+
+	return ws
+}
+
+func (ws *imageWorkspace) Close() {
+	if ws.storage != nil {
+		ws.storage.Close()
+	}
+	os.RemoveAll(ws.dir)
+}
+
+// fatalf removes the work directory, which log.Fatalf would leave behind
+func (ws *imageWorkspace) fatalf(format string, args ...interface{}) {
+	ws.Close()
+	log.Fatalf(format, args...)
+}
+
+func baselineImage(ctx context.Context, imagePath, ref string, monitored []string, output, keyPath, description string) {
+	ws := openImageWorkspace(imagePath, ref, monitored)
+	defer ws.Close()
+
+	files, err := ws.scan.ScanAll(ctx)
+	if err != nil {
+		ws.fatalf("Failed to scan image: %v", err)
+	}
+	for _, file := range files {
+		if err := ws.comp.StoreChecksum(ctx, file); err != nil {
+			if ctx.Err() != nil {
+				ws.fatalf("Interrupted")
+			}
+			log.Printf("Warning: failed to store checksum for %s: %v", file, err)
+		}
+	}
+
+	if description == "" {
+		description = "container image " + ws.ref
+	}
+	exportBaseline(ws.storage, output, keyPath, description)
+}
+
+// verifyImage reports how the image differs from the baseline and whether
+// it matches
+func verifyImage(ctx context.Context, cfg *config.Config, imagePath, ref string, monitored []string, baselinePath string) bool {
+	doc, err := baseline.ReadDocument(baselinePath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", baselinePath, err)
+	}
+	if err := doc.Validate(); err != nil {
+		log.Fatalf("Invalid baseline document: %v", err)
+	}
+	if cfg.Baseline.PublicKey != "" {
+		pub, err := baseline.LoadPublicKey(cfg.Baseline.PublicKey)
+		if err != nil {
+			log.Fatalf("Failed to load baseline public key: %v", err)
+		}
+		if err := doc.VerifySignature(pub); err != nil {
+			log.Fatalf("Refusing to use baseline: %v", err)
+		}
+	}
+
+	ws := openImageWorkspace(imagePath, ref, monitored)
+	defer ws.Close()
+
+	expected := doc.Utilities()
+	if _, err := ws.storage.ImportBaseline(expected, true, "imported from "+baselinePath); err != nil {
+		ws.fatalf("Failed to load baseline: %v", err)
+	}
+
+	files, err := ws.scan.ScanAll(ctx)
+	if err != nil {
+		ws.fatalf("Failed to scan image: %v", err)
+	}
+
+	byPath := make(map[string]*models.Utility, len(expected))
+	for _, util := range expected {
+		byPath[util.Path] = util
+	}
+
+	var changes []baseline.Change
+	found := make(map[string]bool, len(files))
+	for _, file := range files {
+		found[file] = true
+
+		alert, err := ws.comp.CheckFile(ctx, file)
+		if err != nil {
+			if ctx.Err() != nil {
+				ws.fatalf("Interrupted")
+			}
+			log.Printf("Warning: failed to check %s: %v", file, err)
+			continue
+		}
+		if alert != nil {
+			changes = append(changes, baseline.Change{
+				Path: file,
+				Kind: models.ChangeUpdated,
+				Old:  byPath[file],
+				New:  &models.Utility{Path: file, Checksum: alert.NewChecksum},
+			})
+			continue
+		}
+
+		if byPath[file] == nil {
+			if err := ws.comp.StoreChecksum(ctx, file); err != nil {
+				if ctx.Err() != nil {
+					ws.fatalf("Interrupted")
+				}
+				log.Printf("Warning: failed to checksum %s: %v", file, err)
+				continue
+			}
+			util, _ := ws.storage.GetUtility(file)
+			changes = append(changes, baseline.Change{Path: file, Kind: models.ChangeAdded, New: util})
+		}
+	}
+
+	for _, util := range expected {
+		if !found[util.Path] {
+			changes = append(changes, baseline.Change{Path: util.Path, Kind: models.ChangeRemoved, Old: util})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	printChanges(changes)
+	if len(changes) > 0 {
+		fmt.Printf("Image %s does NOT match %s\n", ws.ref, baselinePath)
+		return false
+	}
+	fmt.Printf("Image %s matches %s (%d files)\n", ws.ref, baselinePath, len(expected))
+	return true
+}
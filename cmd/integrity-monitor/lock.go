@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/control"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/instance"
+)
+
+// Holders of the database lock, as shown to whoever finds it taken
+const (
+	lockMonitor = "monitor"
+	lockInit    = "init"
+	lockScan    = "scan"
+)
+
+// lockDatabase takes the instance lock of the database at dbPath or stops
+// the process, telling the operator who holds it
+func lockDatabase(dbPath, command string) *instance.Lock {
+	lock, err := instance.Acquire(dbPath, command)
+	if err != nil {
+		exitLocked(dbPath, err)
+	}
+	return lock
+}
+
+// exitLocked stops the process after Acquire failed with err
+func exitLocked(dbPath string, err error) {
+	var locked *instance.LockedError
+	switch {
+	case lockedByDaemon(err):
+		errors.As(err, &locked)
+		log.Fatalf("Database %s is in use by the running daemon (pid %d); stop it first "+
+			"('systemctl stop integrity-monitor') or go through it with 'integrity-monitor ctl'",
+			dbPath, locked.Holder.PID)
+	case errors.As(err, &locked):
+		log.Fatalf("Database %s is in use: %v; try again once it is done", dbPath, err)
+	default:
+		log.Fatalf("Failed to lock database: %v", err)
+	}
+}
+
+// lockedByDaemon reports whether err says the monitoring daemon holds the lock
+func lockedByDaemon(err error) bool {
+	var locked *instance.LockedError
+	return errors.As(err, &locked) && locked.Holder != nil && locked.Holder.Command == lockMonitor
+}
+
+// openStorageExclusive is openStorage for commands that replace the
+// baseline: it refuses to run next to the daemon or another such command
+func openStorageExclusive(configPath, command string) (*config.Config, *database.SQLiteStorage, func()) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	lock := lockDatabase(cfg.Database.Path, command)
+
+	storage, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	return cfg, storage, func() {
+		storage.Close()
+		lock.Release()
+	}
+}
+
+// scanThroughDaemon runs a one-time scan in the daemon that holds the
+// database instead of racing its writes
+func scanThroughDaemon(cfg *config.Config) {
+	client, err := control.Dial(cfg.Control.Socket)
+	if err != nil {
+		log.Fatalf("The daemon is monitoring %s but its control socket cannot be reached (%v); "+
+			"stop it to scan without it", cfg.Database.Path, err)
+	}
+
+	log.Println("The daemon is running, scanning through it...")
+	result, err := client.Scan(true)
+	if err != nil {
+		log.Fatalf("Scan failed: %v", err)
+	}
+	printScanResult(result)
+}
@@ -1,30 +1,70 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"integrity-monitor/internal/auditlog"
+	"integrity-monitor/internal/baseline"
 	"integrity-monitor/internal/checksum"
 	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/control"
 	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/health"
+	"integrity-monitor/internal/instance"
+	"integrity-monitor/internal/metrics"
 	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/packages"
+	"integrity-monitor/internal/rootfs"
 	"integrity-monitor/internal/scanner"
-	"integrity-monitor/internal/watcher"
+	"integrity-monitor/internal/systemd"
 )
 
-const version = "1.0.0"
+const (
+	version           = "1.0.0"
+	defaultConfigPath = "/etc/integrity-monitor/config.yaml"
+)
+
+// subcommands are invoked as "integrity-monitor <name> [flags]"
+var subcommands = map[string]func(args []string){
+	"baseline":    runBaselineCommand,
+	"config":      runConfigCommand,
+	"ctl":         runCtlCommand,
+	"db":          runDBCommand,
+	"history":     runHistoryCommand,
+	"image":       runImageCommand,
+	"maintenance": runMaintenanceCommand,
+	"outbox":      runOutboxCommand,
+	"packages":    runPackagesCommand,
+	"self":        runSelfCommand,
+	"snapshot":    runSnapshotCommand,
+	"verify-log":  runVerifyLogCommand,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command line flags
 	initCmd := flag.Bool("init", false, "Initialize database with current system state")
 	scanCmd := flag.Bool("scan", false, "Perform a one-time scan of all utilities")
-	configPath := flag.String("config", "/etc/integrity-monitor/config.yaml", "Path to configuration file")
+	configPath := flag.String("config", defaultConfigPath, "Path to configuration file")
 	versionFlag := flag.Bool("version", false, "Show version information")
+	rootDir := flag.String("root", "", "Root directory of the system to check (mounted image, chroot or container rootfs)")
 
 	flag.Parse()
 
@@ -39,43 +79,190 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	root, err := rootfs.New(*rootDir)
+	if err != nil {
+		log.Fatalf("Invalid root directory: %v", err)
+	}
+	if !root.IsHost() {
+		log.Printf("Checking filesystem mounted at %s", root)
+	}
+	validateConfig(cfg, root)
+
+	// Only one process at a time may change the database; a scan requested
+	// while the daemon holds it is run by the daemon
+	command := lockMonitor
+	switch {
+	case *initCmd:
+		command = lockInit
+	case *scanCmd:
+		command = lockScan
+	}
+	lock, err := instance.Acquire(cfg.Database.Path, command)
+	if *scanCmd && lockedByDaemon(err) {
+		scanThroughDaemon(cfg)
+		return
+	}
+	if err != nil {
+		exitLocked(cfg.Database.Path, err)
+	}
+	defer lock.Release()
+
 	// Initialize database
-	storage, err := database.NewSQLiteStorage(cfg.Database.Path)
+	storage, err := openDatabase(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer storage.Close()
 
-	comp := checksum.NewComparator(storage)
-	scan := scanner.NewScanner(cfg.MonitoredPaths)
+	comp := checksum.NewComparator(storage, root)
+	comp.SetMaintenance(newMaintenance(cfg, root))
+	comp.SetSignedBaseline(cfg.Baseline.PublicKey != "")
+	scan := scanner.NewScanner(cfg.MonitoredPaths, root)
+	dispatcher := newDispatcher(cfg, storage)
+	comp.SetNotifier(dispatcher)
+	verifier := newBaselineVerifier(cfg, storage)
+
+	// SIGINT and SIGTERM cancel the work in progress instead of killing the
+	// process, so the database is closed cleanly
+	ctx, stop := interruptContext()
+	defer stop()
 
 	// Handle commands
 	switch {
 	case *initCmd:
-		initializeDatabase(scan, comp)
+		pkgs := loadPackages(cfg, root)
+		comp.SetPackages(pkgs)
+		initializeDatabase(ctx, storage, scan, comp, pkgs, root)
 	case *scanCmd:
-		performScan(scan, comp, cfg)
+		requireTrustedBaseline(verifier)
+		performScan(ctx, scan, comp, dispatcher)
 	default:
 		// Default to monitoring
-		startMonitoring(cfg, storage, scan, comp)
+		requireTrustedBaseline(verifier)
+		if err := startMonitoring(ctx, cfg, *configPath, storage, root, scan, comp, dispatcher, verifier); err != nil {
+			storage.Close()
+			lock.Release()
+			log.Fatalf("Monitoring stopped: %v", err)
+		}
 	}
 }
 
+// interruptContext is cancelled by SIGINT or SIGTERM
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
 func loadConfig(configPath string) (*config.Config, error) {
-	// Try to load from file
-	if _, err := os.Stat(configPath); err == nil {
-		return config.Load(configPath)
+	// Drop-ins and environment overrides still apply over the defaults
+	if _, err := os.Stat(configPath); err != nil {
+		log.Printf("Config file not found, using defaults")
+	}
+	return config.Load(configPath)
+}
+
+// buildChannels creates every configured notifier keyed by its outbox channel name
+func buildChannels(cfg *config.Config) map[string]notifier.Notifier {
+	messages, err := notifier.NewMessages(cfg.Notifiers.Locale, cfg.Notifiers.Templates)
+	if err != nil {
+		log.Printf("Warning: invalid alert messages, using built-in English: %v", err)
+		messages = notifier.DefaultMessages()
+	}
+
+	channels := map[string]notifier.Notifier{
+		"tty": notifier.NewTTYNotifier(cfg.LogFile, notifier.TTYOptions{
+			UtmpFile: cfg.Notifiers.TTY.UtmpFile,
+			Groups:   cfg.Notifiers.TTY.Groups,
+			Messages: messages,
+		}),
 	}
 
-	// Use default configuration
-	log.Printf("Config file not found, using defaults")
-	return config.Default(), nil
+	for i, wh := range cfg.Notifiers.Webhooks {
+		name := wh.Name
+		if name == "" {
+			name = fmt.Sprintf("webhook-%d", i)
+		}
+
+		n, err := notifier.NewWebhookNotifier(notifier.WebhookOptions{
+			URL:             wh.URL,
+			Method:          wh.Method,
+			Headers:         wh.Headers,
+			BodyTemplate:    wh.BodyTemplate,
+			Secret:          wh.Secret,
+			SignatureHeader: wh.SignatureHeader,
+			Timeout:         time.Duration(wh.Timeout),
+			MaxRetries:      wh.MaxRetries,
+			RetryBackoff:    time.Duration(wh.RetryBackoff),
+			Messages:        messages,
+		})
+		if err != nil {
+			log.Printf("Warning: skipping webhook %s: %v", name, err)
+			continue
+		}
+		channels[name] = n
+	}
+
+	if email := cfg.Notifiers.Email; email.Enabled {
+		n, err := notifier.NewEmailNotifier(notifier.EmailOptions{
+			Host:       email.Host,
+			Port:       email.Port,
+			Username:   email.Username,
+			Password:   email.Password,
+			From:       email.From,
+			StartTLS:   email.StartTLS,
+			Recipients: email.Recipients,
+			Digest:     email.Digest,
+			Immediate:  email.ImmediateSeverities,
+			Timeout:    time.Duration(email.Timeout),
+			Messages:   messages,
+		})
+		if err != nil {
+			log.Printf("Warning: skipping email notifier: %v", err)
+		} else {
+			channels["email"] = n
+		}
+	}
+
+	return channels
 }
 
-func initializeDatabase(scan *scanner.Scanner, comp *checksum.Comparator) {
+// newDispatcher registers the configured channels with the storage outbox
+func newDispatcher(cfg *config.Config, storage database.Storage) *notifier.Dispatcher {
+	return notifier.NewDispatcher(storage, buildChannels(cfg), notifier.DispatcherOptions{
+		PollInterval: time.Duration(cfg.Outbox.PollInterval),
+		RetryBackoff: time.Duration(cfg.Outbox.RetryBackoff),
+		MaxBackoff:   time.Duration(cfg.Outbox.MaxBackoff),
+		MaxAttempts:  cfg.Outbox.MaxAttempts,
+	})
+}
+
+// openDatabase opens the configured database for a process that holds the
+// instance lock, migrating its schema first if auto_migrate is set
+func openDatabase(cfg *config.Config) (*database.SQLiteStorage, error) {
+	if cfg.Database.AutoMigrate {
+		return database.NewMigratedSQLiteStorage(cfg.Database.Path)
+	}
+	return database.NewSQLiteStorage(cfg.Database.Path)
+}
+
+// openStorage loads the configuration and opens its database for a subcommand
+func openStorage(configPath string) (*config.Config, *database.SQLiteStorage) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	storage, err := database.NewSQLiteStorage(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	return cfg, storage
+}
+
+func initializeDatabase(ctx context.Context, storage database.Storage, scan *scanner.Scanner, comp *checksum.Comparator, pkgs *packages.Database, root rootfs.Root) {
 	log.Println("Initializing database with current system state...")
 
-	utilities, err := scan.ScanAll()
+	utilities, err := scan.ScanAll(ctx)
 	if err != nil {
 		log.Fatalf("Failed to scan utilities: %v", err)
 	}
@@ -88,7 +275,11 @@ func initializeDatabase(scan *scanner.Scanner, comp *checksum.Comparator) {
 			log.Printf("Progress: %d/%d utilities processed", i+1, len(utilities))
 		}
 
-		if err := comp.StoreChecksum(util); err != nil {
+		if err := comp.StoreChecksum(ctx, util); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Initialization interrupted after %d/%d utilities, no baseline snapshot recorded", successCount, len(utilities))
+				return
+			}
 			log.Printf("Warning: failed to store checksum for %s: %v", util, err)
 			continue
 		}
@@ -96,23 +287,46 @@ func initializeDatabase(scan *scanner.Scanner, comp *checksum.Comparator) {
 	}
 
 	log.Printf("Initialization complete! Stored checksums for %d/%d utilities", successCount, len(utilities))
+
+	if pkgs != nil {
+		summary := verifyPackages(pkgs, root, utilities, func(m packageMismatch) {
+			if err := storage.SaveAlert(m.alert()); err != nil {
+				log.Printf("Failed to save alert: %v", err)
+			}
+		})
+		log.Printf("Package check: %s", summary)
+		if summary.Mismatched > 0 {
+			log.Printf("SECURITY: %d utilities differ from their packages and were baselined as found; "+
+				"investigate before trusting this baseline", summary.Mismatched)
+		}
+	}
+
+	snap, err := storage.CreateSnapshot("baseline initialized with -init")
+	if err != nil {
+		log.Fatalf("Failed to create baseline snapshot: %v", err)
+	}
+	log.Printf("Baseline recorded as generation %d", snap.ID)
+	log.Println("If baseline signing is enabled, sign the new baseline with 'integrity-monitor baseline sign'")
 }
 
-func performScan(scan *scanner.Scanner, comp *checksum.Comparator, cfg *config.Config) {
+func performScan(ctx context.Context, scan *scanner.Scanner, comp *checksum.Comparator, dispatcher *notifier.Dispatcher) {
 	log.Println("Performing one-time scan...")
 
-	utilities, err := scan.ScanAll()
+	utilities, err := scan.ScanAll(ctx)
 	if err != nil {
 		log.Fatalf("Failed to scan utilities: %v", err)
 	}
 
 	log.Printf("Checking %d utilities", len(utilities))
 
-	notif := notifier.NewTTYNotifier(cfg.LogFile)
 	alertCount := 0
 
 	for _, util := range utilities {
-		alert, err := comp.CheckFile(util)
+		alert, err := comp.CheckFile(ctx, util)
+		if ctx.Err() != nil {
+			log.Println("Scan interrupted")
+			break
+		}
 		if err != nil {
 			log.Printf("Error checking %s: %v", util, err)
 			continue
@@ -121,10 +335,19 @@ func performScan(scan *scanner.Scanner, comp *checksum.Comparator, cfg *config.C
 		if alert != nil {
 			alertCount++
 			log.Printf("ALERT: %s has been modified!", util)
-			notif.SendAlert(alert)
+			dispatcher.SendAlert(ctx, alert)
 		}
 	}
 
+	// Alerts were queued by the comparator; deliver them before exiting.
+	// An interrupted delivery leaves them in the outbox for the daemon.
+	if err := dispatcher.Flush(ctx); err != nil {
+		log.Printf("Failed to deliver alerts: %v", err)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
 	if alertCount == 0 {
 		log.Println("Scan complete: No modifications detected")
 	} else {
@@ -132,73 +355,184 @@ func performScan(scan *scanner.Scanner, comp *checksum.Comparator, cfg *config.C
 	}
 }
 
-func startMonitoring(cfg *config.Config, storage database.Storage, scan *scanner.Scanner, comp *checksum.Comparator) {
+// startMonitoring runs the daemon until ctx is cancelled, SIGINT or SIGTERM
+// arrive or a loop fails; it returns the cause of a failure
+func startMonitoring(ctx context.Context, cfg *config.Config, configPath string, storage database.Storage, root rootfs.Root, scan *scanner.Scanner, comp *checksum.Comparator, dispatcher *notifier.Dispatcher, verifier *baseline.Verifier) error {
 	log.Println("Starting Integrity Monitor...")
 	log.Printf("Monitoring paths: %v", cfg.MonitoredPaths)
-	log.Printf("Scan interval: %d seconds", cfg.ScanInterval)
+	for _, s := range cfg.ScanSchedules() {
+		if s.Cron != "" {
+			log.Printf("Schedule %s: %s scan at %s", s.Name, s.Mode, s.Cron)
+		} else {
+			log.Printf("Schedule %s: %s scan every %s", s.Name, s.Mode, s.Every)
+		}
+	}
 
-	notif := notifier.NewTTYNotifier(cfg.LogFile)
+	if cfg.PIDFile != "" {
+		if err := instance.WritePIDFile(cfg.PIDFile); err != nil {
+			log.Printf("Warning: %v", err)
+		} else {
+			defer instance.RemovePIDFile(cfg.PIDFile)
+		}
+	}
 
-	// Start periodic scanner
-	go startPeriodicScan(scan, comp, notif, cfg.ScanInterval)
+	d := newDaemon(ctx, cfg, configPath, storage, root, scan, comp, dispatcher, verifier)
+
+	// Watch the monitor's own executable, configuration and baseline
+	self, err := newSelfChecker(cfg, configPath, storage, dispatcher)
+	if err != nil {
+		return err
+	}
+	if self != nil {
+		d.self = self
+		d.spawn(func() { self.run(d.ctx, time.Duration(cfg.SelfCheck.Interval)) })
+	}
 
-	// Start file watcher if enabled
+	// Start file watcher if enabled; reloads may start or stop it later
 	if cfg.EnableWatcher {
-		handler := watcher.CreateFileChangeHandler(comp, notif)
-		w, err := watcher.NewWatcher(cfg.MonitoredPaths, handler)
+		if err := d.startWatcher(cfg.MonitoredPaths); err != nil {
+			return fmt.Errorf("failed to create watcher: %w", err)
+		}
+	}
+
+	// Accept control requests from local administrators
+	var server *control.Server
+	if cfg.Control.Socket != "" {
+		var err error
+		server, err = control.Listen(cfg.Control.Socket, d, control.ServerOptions{Group: cfg.Control.Group})
 		if err != nil {
-			log.Fatalf("Failed to create watcher: %v", err)
+			d.shutdown()
+			return fmt.Errorf("failed to open control socket: %w", err)
 		}
-		defer w.Close()
 
 		go func() {
-			if err := w.Start(); err != nil {
-				log.Fatalf("Watcher error: %v", err)
+			if err := server.Serve(); err != nil {
+				log.Printf("Control socket error: %v", err)
 			}
 		}()
+		log.Printf("Control socket: %s", cfg.Control.Socket)
 	}
 
-	// Wait for interrupt signal
+	// Expose the monitor's own health to Prometheus
+	metrics.BuildInfo.Set(1, version)
+	metrics.StartTime.Set(float64(time.Now().Unix()))
+	metrics.Paused.Set(0)
+	var metricsServer *http.Server
+	if cfg.Metrics.Listen != "" {
+		listener, err := net.Listen("tcp", cfg.Metrics.Listen)
+		if err != nil {
+			if server != nil {
+				server.Close()
+			}
+			d.shutdown()
+			return fmt.Errorf("failed to open metrics listener: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", d.health.LivenessHandler())
+		mux.Handle("/readyz", d.health.ReadinessHandler())
+		metricsServer = &http.Server{Handler: mux}
+
+		go func() {
+			if err := metricsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Printf("Metrics listener error: %v", err)
+			}
+		}()
+		log.Printf("Serving metrics and health checks on http://%s", listener.Addr())
+	}
+
+	// Deliver queued alerts, including those left over from a previous run
+	d.spawn(func() { dispatcher.Run(d.ctx) })
+
+	// Publish the alert chain head outside the host
+	if cfg.AlertChain.ExportFile != "" || cfg.AlertChain.ExportURL != "" {
+		exporter := auditlog.NewExporter(storage, auditlog.ExportOptions{
+			File:     cfg.AlertChain.ExportFile,
+			URL:      cfg.AlertChain.ExportURL,
+			Interval: time.Duration(cfg.AlertChain.ExportInterval),
+		})
+		d.spawn(func() { exporter.Run(d.ctx) })
+	}
+
+	// Start periodic scanner
+	d.spawn(d.runScans)
+
+	// Close maintenance windows that run out without being ended
+	d.spawn(d.expireMaintenance)
+
+	// SIGINT and SIGTERM cancel ctx; SIGHUP reloads the configuration
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	// Tell systemd that startup is complete and keep its watchdog fed
+	// while the scan and watcher loops make progress
+	if _, err := systemd.Notify("READY=1"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		log.Printf("systemd watchdog enabled, keep-alive every %s", interval/2)
+		go feedWatchdog(d.ctx, d.health, interval/2)
+	}
 
 	log.Println("Monitoring active. Press Ctrl+C to stop.")
-	<-sigChan
+	for running := true; running; {
+		select {
+		case <-sigChan:
+			log.Println("Received SIGHUP, reloading configuration...")
+			d.Reload()
+		case <-d.ctx.Done():
+			running = false
+		}
+	}
 
 	log.Println("Shutting down...")
+	systemd.Notify("STOPPING=1")
+
+	// Stop accepting requests and let the ones in progress finish before
+	// the loops that serve them go away, so none spawns work into a
+	// shutdown already waiting for the loops
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.config().ShutdownTimeout))
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Control requests still running at shutdown: %v", err)
+		}
+		cancel()
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	d.shutdown()
+	d.stopWatcher()
+
+	if err := context.Cause(d.ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	log.Println("Shutdown complete")
+	return nil
 }
 
-func startPeriodicScan(scan *scanner.Scanner, comp *checksum.Comparator, notif notifier.Notifier, interval int) {
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+// feedWatchdog pings the systemd watchdog only while every loop makes
+// progress, so that systemd restarts a daemon whose scan or watcher hangs
+func feedWatchdog(ctx context.Context, monitor *health.Monitor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		log.Println("Starting periodic scan...")
-
-		utilities, err := scan.ScanAll()
-		if err != nil {
-			log.Printf("Scan error: %v", err)
-			continue
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		alertCount := 0
-		for _, util := range utilities {
-			alert, err := comp.CheckFile(util)
-			if err != nil {
-				log.Printf("Error checking %s: %v", util, err)
-				continue
-			}
-
-			if alert != nil {
-				alertCount++
-				notif.SendAlert(alert)
-			}
+		report := monitor.Check()
+		if !report.Healthy {
+			log.Printf("Watchdog: %s stopped making progress, withholding keep-alive",
+				strings.Join(report.Unhealthy(), ", "))
+			continue
 		}
-
-		if alertCount > 0 {
-			log.Printf("Periodic scan complete: %d alerts generated", alertCount)
-		} else {
-			log.Println("Periodic scan complete: No modifications detected")
+		if _, err := systemd.Notify("WATCHDOG=1"); err != nil {
+			log.Printf("Failed to notify systemd watchdog: %v", err)
 		}
 	}
 }
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+
+	"integrity-monitor/internal/checksum"
+	"integrity-monitor/internal/control"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/rootfs"
+	"integrity-monitor/pkg/models"
+)
+
+// runMaintenanceCommand opens and closes maintenance windows. The daemon
+// consults open windows in the database before raising an alert, so no
+// restart is needed. When the daemon is running the commands go through
+// its control socket.
+func runMaintenanceCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor maintenance start -paths /usr/local/bin[,...] [-duration 30m] -reason "deploy app 1.4"
+  integrity-monitor maintenance list [-all]
+  integrity-monitor maintenance show <id>
+  integrity-monitor maintenance end [-id N] [-approve | -reject]`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("maintenance "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	paths := fs.String("paths", "", "Comma-separated files or directories covered by the window")
+	duration := fs.Duration("duration", 30*time.Minute, "How long the window stays open")
+	reason := fs.String("reason", "", "Why the window is opened")
+	all := fs.Bool("all", false, "Also list ended windows")
+	id := fs.Int64("id", 0, "Window to end (default: the only open one)")
+	approve := fs.Bool("approve", false, "Accept all queued changes into the baseline")
+	reject := fs.Bool("reject", false, "End without accepting; the changes are then alerted by the next scan")
+	fs.Parse(args[1:])
+
+	api, closeAPI := openMaintenance(*configPath, args[0] == "end")
+	defer closeAPI()
+
+	switch args[0] {
+	case "start":
+		if *paths == "" || *reason == "" || *duration <= 0 {
+			usage()
+		}
+		w, err := api.StartMaintenance(control.StartMaintenanceRequest{
+			Paths:     strings.Split(*paths, ","),
+			Duration:  duration.String(),
+			Reason:    *reason,
+			StartedBy: invokingUser(),
+		})
+		if err != nil {
+			log.Fatalf("Failed to start maintenance window: %v", err)
+		}
+		fmt.Printf("Maintenance window %d open until %s for %s\n",
+			w.ID, w.EndsAt.Format("2006-01-02 15:04:05"), strings.Join(w.Paths, ", "))
+		fmt.Println("Changes in scope are queued; review them with 'integrity-monitor maintenance end'")
+	case "list":
+		listMaintenance(api, !*all)
+	case "show":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		windowID, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid maintenance window %q", fs.Arg(0))
+		}
+		detail, err := api.Maintenance(windowID)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		printMaintenanceSummary(detail)
+	case "end":
+		if *approve && *reject {
+			usage()
+		}
+		endMaintenance(api, *id, *approve, *reject)
+	default:
+		usage()
+	}
+}
+
+// openMaintenance talks to the running daemon if there is one and to the
+// database otherwise. Ending a window may approve changes into the
+// baseline, so without the daemon it needs exclusive use of the database.
+func openMaintenance(configPath string, exclusive bool) (control.MaintenanceAPI, func()) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	client, err := control.Dial(cfg.Control.Socket)
+	if err == nil {
+		return client, func() {}
+	}
+	if !errors.Is(err, control.ErrNotRunning) {
+		log.Fatalf("Failed to reach the daemon at %s: %v", cfg.Control.Socket, err)
+	}
+
+	if exclusive {
+		_, storage, release := openStorageExclusive(configPath, "maintenance end")
+		return &maintenanceService{storage: storage}, release
+	}
+	_, storage := openStorage(configPath)
+	return &maintenanceService{storage: storage}, func() { storage.Close() }
+}
+
+// invokingUser names the operator, seeing through sudo. It is recorded only
+// when the database is used directly; the daemon names the caller by the
+// uid the kernel reports for the control connection.
+func invokingUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		name = sudoUser + " (via sudo)"
+	}
+	return name
+}
+
+func listMaintenance(api control.MaintenanceAPI, openOnly bool) {
+	windows, err := api.MaintenanceWindows(openOnly)
+	if err != nil {
+		log.Fatalf("Failed to list maintenance windows: %v", err)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSTARTED\tENDS\tBY\tPATHS\tREASON")
+	for _, win := range windows {
+		status := win.Status
+		if status == models.MaintenanceOpen && !win.Active(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", win.ID, status,
+			win.StartedAt.Format("2006-01-02 15:04"), win.EndsAt.Format("2006-01-02 15:04"),
+			win.StartedBy, strings.Join(win.Paths, ","), win.Reason)
+	}
+	w.Flush()
+}
+
+func printMaintenanceSummary(detail *control.MaintenanceDetail) {
+	for _, line := range maintenanceSummary(detail) {
+		fmt.Println(line)
+	}
+}
+
+// maintenanceSummary describes a window and the changes queued in it
+func maintenanceSummary(detail *control.MaintenanceDetail) []string {
+	w := detail.Window
+	lines := []string{fmt.Sprintf("Maintenance window %d (%s), started %s by %s: %s", w.ID, w.Status,
+		w.StartedAt.Format("2006-01-02 15:04:05"), w.StartedBy, w.Reason)}
+	if len(detail.Changes) == 0 {
+		return append(lines, "No changes were queued")
+	}
+	for _, c := range detail.Changes {
+		if c.OldChecksum == "" {
+			lines = append(lines, fmt.Sprintf("+ %s  %s", c.Path, c.NewChecksum))
+		} else {
+			lines = append(lines, fmt.Sprintf("~ %s  %s -> %s", c.Path, c.OldChecksum, c.NewChecksum))
+		}
+	}
+	return append(lines, fmt.Sprintf("%d changes", len(detail.Changes)))
+}
+
+func endMaintenance(api control.MaintenanceAPI, id int64, approve, reject bool) {
+	if id == 0 {
+		windows, err := api.MaintenanceWindows(true)
+		if err != nil {
+			log.Fatalf("Failed to list maintenance windows: %v", err)
+		}
+		switch len(windows) {
+		case 0:
+			log.Fatalf("No maintenance window is open")
+		case 1:
+			id = windows[0].ID
+		default:
+			log.Fatalf("%d maintenance windows are open, select one with -id", len(windows))
+		}
+	}
+
+	detail, err := api.Maintenance(id)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if detail.Window.Status != models.MaintenanceOpen {
+		log.Fatalf("Maintenance window %d is already %s", id, detail.Window.Status)
+	}
+
+	printMaintenanceSummary(detail)
+	if len(detail.Changes) > 0 && !approve && !reject {
+		approve = confirm("Approve all changes into the baseline?")
+	}
+
+	result, err := api.EndMaintenance(id, control.EndMaintenanceRequest{Approve: approve})
+	if err != nil {
+		log.Fatalf("Failed to end maintenance window: %v", err)
+	}
+	for _, reason := range result.Skipped {
+		log.Printf("Not approving %s", reason)
+	}
+
+	switch {
+	case result.Window.Status == models.MaintenanceApproved:
+		fmt.Printf("Approved %d/%d changes as generation %d\n", result.Approved, result.Queued, result.Generation)
+		fmt.Println("If baseline signing is enabled, sign the new baseline with 'integrity-monitor baseline sign'")
+	case result.Queued > 0:
+		fmt.Println("Window closed without approval; the changes will be alerted by the next scan")
+	default:
+		fmt.Printf("Maintenance window %d %s\n", id, result.Window.Status)
+	}
+}
+
+// maintenanceService implements control.MaintenanceAPI on the database;
+// the daemon serves it over the control socket
+type maintenanceService struct {
+	storage database.Storage
+	root    rootfs.Root
+}
+
+func (m *maintenanceService) StartMaintenance(req control.StartMaintenanceRequest) (*models.MaintenanceWindow, error) {
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %q", req.Duration)
+	}
+	if len(req.Paths) == 0 || req.Reason == "" {
+		return nil, fmt.Errorf("paths and reason are required")
+	}
+	paths := make([]string, len(req.Paths))
+	for i, path := range req.Paths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("maintenance paths must be absolute: %s", path)
+		}
+		paths[i] = filepath.Clean(path)
+	}
+
+	now := time.Now()
+	w := &models.MaintenanceWindow{
+		Paths:     paths,
+		Reason:    req.Reason,
+		StartedBy: req.StartedBy,
+		StartedAt: now,
+		EndsAt:    now.Add(duration),
+	}
+	if err := m.storage.StartMaintenance(w); err != nil {
+		return nil, err
+	}
+	log.Printf("Maintenance window %d opened by %s for %s until %s: %s", w.ID, w.StartedBy,
+		strings.Join(paths, ", "), w.EndsAt.Format("2006-01-02 15:04:05"), w.Reason)
+	return w, nil
+}
+
+func (m *maintenanceService) MaintenanceWindows(openOnly bool) ([]*models.MaintenanceWindow, error) {
+	limit := 50
+	if openOnly {
+		limit = -1
+	}
+	return m.storage.GetMaintenanceWindows(openOnly, limit)
+}
+
+func (m *maintenanceService) Maintenance(id int64) (*control.MaintenanceDetail, error) {
+	w, err := m.storage.GetMaintenanceWindow(id)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := m.storage.GetMaintenanceChanges(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queued changes: %w", err)
+	}
+	return &control.MaintenanceDetail{Window: w, Changes: changes}, nil
+}
+
+func (m *maintenanceService) EndMaintenance(id int64, req control.EndMaintenanceRequest) (*control.EndMaintenanceResult, error) {
+	detail, err := m.Maintenance(id)
+	if err != nil {
+		return nil, err
+	}
+	w := detail.Window
+	if w.Status != models.MaintenanceOpen {
+		return nil, fmt.Errorf("maintenance window %d is already %s", id, w.Status)
+	}
+
+	result := &control.EndMaintenanceResult{Queued: len(detail.Changes)}
+	status := models.MaintenanceClosed
+	if req.Approve && len(detail.Changes) > 0 {
+		if err := m.approveChanges(w, detail.Changes, result); err != nil {
+			return nil, err
+		}
+		status = models.MaintenanceApproved
+	}
+	if err := m.storage.CloseMaintenanceWindow(id, status); err != nil {
+		return nil, fmt.Errorf("failed to close maintenance window: %w", err)
+	}
+	log.Printf("Maintenance window %d %s", id, status)
+
+	if result.Window, err = m.storage.GetMaintenanceWindow(id); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// expireWindows closes the open windows that ran out at now without being
+// ended and returns them with their changes, which stay unapproved
+func (m *maintenanceService) expireWindows(now time.Time) ([]*control.MaintenanceDetail, error) {
+	windows, err := m.storage.GetMaintenanceWindows(true, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*control.MaintenanceDetail
+	for _, w := range windows {
+		if w.Active(now) {
+			continue
+		}
+		if err := m.storage.CloseMaintenanceWindow(w.ID, models.MaintenanceExpired); err != nil {
+			// Ended by an operator meanwhile
+			continue
+		}
+		detail, err := m.Maintenance(w.ID)
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, detail)
+	}
+	return expired, nil
+}
+
+// approveChanges accepts the files whose content is still what the window
+// recorded; anything modified again since is left to be alerted
+func (m *maintenanceService) approveChanges(w *models.MaintenanceWindow, changes []*models.MaintenanceChange, result *control.EndMaintenanceResult) error {
+	var entries []*models.Utility
+	for _, c := range changes {
+		hostPath, err := m.root.Resolve(c.Path)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", c.Path, err))
+			continue
+		}
+		info, err := os.Stat(hostPath)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", c.Path, err))
+			continue
+		}
+		current, err := checksum.CalculateSHA256(context.Background(), hostPath)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", c.Path, err))
+			continue
+		}
+		if current != c.NewChecksum {
+			result.Skipped = append(result.Skipped, c.Path+": modified again since it was queued")
+			continue
+		}
+		entries = append(entries, &models.Utility{
+			Path:         c.Path,
+			Checksum:     current,
+			LastModified: info.ModTime(),
+			Size:         info.Size(),
+		})
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no changes could be approved: %s", strings.Join(result.Skipped, "; "))
+	}
+
+	snap, err := m.storage.ImportBaseline(entries, false, fmt.Sprintf("maintenance window %d approved: %s", w.ID, w.Reason))
+	if err != nil {
+		return fmt.Errorf("failed to approve changes: %w", err)
+	}
+	result.Approved = len(entries)
+	result.Generation = snap.ID
+	return nil
+}
+
+// confirm asks a yes/no question on the terminal; anything but yes is no.
+// Without a terminal the window is left open for an explicit decision.
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		log.Fatalf("Not a terminal; end the window with -approve or -reject")
+	}
+
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// isTerminal reports whether f is a terminal; /dev/null is a character
+// device too, so ask for the terminal attributes
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
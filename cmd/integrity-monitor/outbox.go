@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// runOutboxCommand shows alert deliveries and requeues abandoned ones
+func runOutboxCommand(args []string) {
+	fs := flag.NewFlagSet("outbox", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	status := fs.String("status", "", "Only show entries with this status (pending, delivered, failed)")
+	limit := fs.Int("limit", 50, "Maximum number of entries to show")
+	retry := fs.Bool("retry", false, "Requeue failed deliveries")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: integrity-monitor outbox [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	_, storage := openStorage(*configPath)
+	defer storage.Close()
+
+	if *retry {
+		n, err := storage.RequeueFailedDeliveries()
+		if err != nil {
+			log.Fatalf("Failed to requeue deliveries: %v", err)
+		}
+		fmt.Printf("Requeued %d failed deliveries\n", n)
+		return
+	}
+
+	deliveries, err := storage.GetDeliveries(*status, *limit)
+	if err != nil {
+		log.Fatalf("Failed to read outbox: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tALERT\tCHANNEL\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tPATH\tLAST ERROR")
+	for _, d := range deliveries {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			d.ID, d.Alert.ID, d.Channel, d.Status, d.Attempts,
+			d.NextAttemptAt.Format("2006-01-02 15:04:05"), d.Alert.UtilityPath, d.LastError)
+	}
+	w.Flush()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/packages"
+	"integrity-monitor/internal/rootfs"
+	"integrity-monitor/internal/scanner"
+	"integrity-monitor/pkg/models"
+)
+
+// runPackagesCommand cross-checks utilities against the package manager
+func runPackagesCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor packages verify [-root /mnt/image] [-v]`)
+		os.Exit(2)
+	}
+	if len(args) == 0 || args[0] != "verify" {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("packages verify", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	rootDir := fs.String("root", "", "Root directory of the system to check")
+	verbose := fs.Bool("v", false, "Also list files no package owns")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	root, err := rootfs.New(*rootDir)
+	if err != nil {
+		log.Fatalf("Invalid root directory: %v", err)
+	}
+
+	pkgs, err := packages.Load(root)
+	if err != nil {
+		log.Fatalf("Failed to load package database: %v", err)
+	}
+
+	files, err := scanner.NewScanner(cfg.MonitoredPaths, root).ScanAll(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to scan utilities: %v", err)
+	}
+
+	summary := verifyPackages(pkgs, root, files, func(m packageMismatch) {
+		fmt.Printf("MISMATCH  %s  %s %s (%s)  expected %s, got %s\n",
+			m.path, m.owner.Package, m.owner.Version, m.owner.Manager, m.owner.Digest, m.actual)
+	})
+	if *verbose {
+		for _, path := range summary.unowned {
+			fmt.Printf("UNOWNED   %s\n", path)
+		}
+	}
+	fmt.Println(summary)
+
+	if summary.Mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadPackages returns nil unless package verification is enabled
+func loadPackages(cfg *config.Config, root rootfs.Root) *packages.Database {
+	if !cfg.Packages.Verify {
+		return nil
+	}
+
+	pkgs, err := packages.Load(root)
+	if err != nil {
+		log.Fatalf("Package verification is enabled but failed: %v", err)
+	}
+	log.Printf("Loaded package digests for %d files", pkgs.Len())
+	return pkgs
+}
+
+// newMaintenance returns nil unless package maintenance handling is enabled
+func newMaintenance(cfg *config.Config, root rootfs.Root) *packages.Maintenance {
+	switch cfg.Packages.Maintenance {
+	case "", packages.MaintenanceOff:
+		return nil
+	case packages.MaintenanceDowngrade:
+	default:
+		log.Fatalf("Invalid packages.maintenance %q (expected off or downgrade)", cfg.Packages.Maintenance)
+	}
+
+	log.Printf("Package manager updates verified against package digests will be approved with medium alerts")
+	return packages.NewMaintenance(root, packages.MaintenanceOptions{
+		Locks:    cfg.Packages.Locks,
+		Logs:     cfg.Packages.Logs,
+		MaxDefer: time.Duration(cfg.Packages.MaxDefer),
+	})
+}
+
+// packageMismatch is a file whose content differs from its package
+type packageMismatch struct {
+	path   string
+	owner  *packages.Owner
+	actual string
+}
+
+func (m packageMismatch) alert() *models.Alert {
+	return &models.Alert{
+		UtilityPath: m.path,
+		OldChecksum: m.owner.Algorithm + ":" + m.owner.Digest,
+		NewChecksum: m.owner.Algorithm + ":" + m.actual,
+		DetectedAt:  time.Now(),
+		Severity:    "critical",
+	}
+}
+
+type packageSummary struct {
+	Verified   int
+	Mismatched int
+	Unowned    int
+	Failed     int
+	unowned    []string
+}
+
+func (s packageSummary) String() string {
+	return fmt.Sprintf("%d match their package, %d MISMATCH, %d not owned by any package, %d could not be checked",
+		s.Verified, s.Mismatched, s.Unowned, s.Failed)
+}
+
+// verifyPackages checks every file against its package digest and calls
+// report for each mismatch
+func verifyPackages(pkgs *packages.Database, root rootfs.Root, files []string, report func(packageMismatch)) packageSummary {
+	var summary packageSummary
+	for _, path := range files {
+		owner, actual, err := pkgs.Verify(root, path)
+		switch {
+		case err != nil:
+			log.Printf("Warning: failed to verify %s against its package: %v", path, err)
+			summary.Failed++
+		case owner == nil:
+			summary.Unowned++
+			summary.unowned = append(summary.unowned, path)
+		case actual != owner.Digest:
+			log.Printf("SECURITY: %s differs from package %s %s", path, owner.Package, owner.Version)
+			summary.Mismatched++
+			report(packageMismatch{path: path, owner: owner, actual: actual})
+		default:
+			summary.Verified++
+		}
+	}
+	return summary
+}
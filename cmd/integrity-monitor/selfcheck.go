@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/config"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/selfcheck"
+	"integrity-monitor/pkg/models"
+)
+
+// runSelfCommand pins the monitor's own files in a signed manifest and
+// checks them against it
+func runSelfCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor self sign -key /media/offline/baseline.key [-exe path] [-o manifest]
+  integrity-monitor self verify`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("self "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	keyPath := fs.String("key", "", "Private key used to sign the manifest (the baseline signing key)")
+	exe := fs.String("exe", "", "Installed monitor executable to pin (default: this one)")
+	output := fs.String("o", "", "Manifest destination (default: self_check.manifest)")
+	fs.Parse(args[1:])
+
+	cfg, storage := openStorage(*configPath)
+	defer storage.Close()
+
+	switch args[0] {
+	case "sign":
+		if *keyPath == "" {
+			usage()
+		}
+		if *output == "" {
+			*output = cfg.SelfCheck.Manifest
+		}
+		if *output == "" {
+			log.Fatalf("self_check.manifest is not configured; pass -o")
+		}
+		if *exe == "" {
+			*exe = executablePath()
+		}
+		priv, err := baseline.LoadPrivateKey(*keyPath)
+		if err != nil {
+			log.Fatalf("Failed to load private key: %v", err)
+		}
+
+		state, err := selfState(*configPath, cfg, storage)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		state.Executable, err = filepath.Abs(*exe)
+		if err != nil {
+			log.Fatalf("Invalid executable path: %v", err)
+		}
+		manifest, err := selfcheck.Pin(context.Background(), state)
+		if err != nil {
+			log.Fatalf("Failed to pin: %v", err)
+		}
+		manifest.Sign(priv)
+		if err := selfcheck.Write(*output, manifest); err != nil {
+			log.Fatalf("Failed to write %s: %v", *output, err)
+		}
+		fmt.Printf("Pinned %s, %d config files, %d environment overrides and baseline root %s in %s (key %s)\n",
+			manifest.Executable.Path, len(manifest.Config), len(state.Environment), manifest.BaselineRoot, *output, manifest.Signature.KeyID)
+	case "verify":
+		checker, err := newSelfChecker(cfg, *configPath, storage, nil)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if checker == nil {
+			log.Fatalf("self_check.manifest is not configured")
+		}
+		manifest, err := checker.read()
+		if err != nil {
+			log.Fatalf("Self-check FAILED: %v", err)
+		}
+		state, err := selfState(*configPath, cfg, storage)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		mismatches := manifest.Check(context.Background(), state)
+		for _, m := range mismatches {
+			fmt.Printf("MISMATCH  %s\n", m)
+		}
+		if len(mismatches) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("Self-check OK: %s, %d config files, the environment and the baseline match the manifest signed %s by key %s\n",
+			manifest.Executable.Path, len(manifest.Config), manifest.CreatedAt.Format("2006-01-02 15:04:05"), manifest.Signature.KeyID)
+	default:
+		usage()
+	}
+}
+
+// executablePath is the installed path of the running monitor
+func executablePath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to find the monitor executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return exe
+}
+
+// selfState lists the configuration files, environment overrides and the
+// baseline root as they are now; paths are made absolute so that -config ./config.yaml pins the
+// same files the service reads
+func selfState(configPath string, cfg *config.Config, storage database.Storage) (selfcheck.State, error) {
+	files, err := config.Files(configPath)
+	if err != nil {
+		return selfcheck.State{}, err
+	}
+	state := selfcheck.State{Database: cfg.Database.Path, Environment: config.EnvOverrides()}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return selfcheck.State{}, err
+		}
+		state.Config = append(state.Config, abs)
+	}
+
+	utilities, err := storage.GetAllUtilities()
+	if err != nil {
+		return selfcheck.State{}, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	state.BaselineRoot = baseline.MerkleRoot(utilities)
+	return state, nil
+}
+
+// selfChecker raises a critical alert when the monitor's own executable,
+// configuration or baseline stop matching the signed manifest. Each
+// mismatch is alerted once until it changes again.
+type selfChecker struct {
+	manifestPath string
+	configPath   string
+	cfg          *config.Config
+	pub          ed25519.PublicKey
+	storage      database.Storage
+	notif        notifier.Notifier
+
+	mu       sync.Mutex
+	manifest *selfcheck.Manifest
+	reported map[string]string
+}
+
+// newSelfChecker returns nil when self_check.manifest is not configured
+func newSelfChecker(cfg *config.Config, configPath string, storage database.Storage, notif notifier.Notifier) (*selfChecker, error) {
+	if cfg.SelfCheck.Manifest == "" {
+		return nil, nil
+	}
+	pub, err := baseline.LoadPublicKey(cfg.Baseline.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load baseline public key: %w", err)
+	}
+	return &selfChecker{
+		manifestPath: cfg.SelfCheck.Manifest,
+		configPath:   configPath,
+		cfg:          cfg,
+		pub:          pub,
+		storage:      storage,
+		notif:        notif,
+		reported:     make(map[string]string),
+	}, nil
+}
+
+// read loads the manifest and verifies its signature
+func (s *selfChecker) read() (*selfcheck.Manifest, error) {
+	manifest, err := selfcheck.Read(s.manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Verify(s.pub); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.manifestPath, err)
+	}
+	return manifest, nil
+}
+
+// load adopts the manifest on disk if its signature verifies; otherwise it
+// alerts and keeps checking against the manifest loaded before, if any
+func (s *selfChecker) load(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	manifest, err := s.read()
+	if err != nil {
+		s.alert(ctx, selfcheck.Mismatch{
+			Kind:     selfcheck.KindManifest,
+			Path:     s.manifestPath,
+			Expected: "signed manifest",
+			Actual:   err.Error(),
+		})
+		return false
+	}
+	delete(s.reported, selfcheck.KindManifest)
+	s.manifest = manifest
+	return true
+}
+
+// check compares the current state with the manifest, alerting mismatches
+// not reported yet, and returns all of them
+func (s *selfChecker) check(ctx context.Context) []selfcheck.Mismatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.manifest == nil {
+		return nil
+	}
+	state, err := selfState(s.configPath, s.cfg, s.storage)
+	if err != nil {
+		log.Printf("Self-check failed: %v", err)
+		return nil
+	}
+
+	mismatches := s.manifest.Check(ctx, state)
+	if ctx.Err() != nil {
+		return nil
+	}
+	current := make(map[string]bool, len(mismatches))
+	for _, m := range mismatches {
+		current[m.Kind+" "+m.Path] = true
+		s.alert(ctx, m)
+	}
+	for key := range s.reported {
+		if key != selfcheck.KindManifest && !current[key] {
+			log.Printf("Self-check: %s matches the manifest again", key)
+			delete(s.reported, key)
+		}
+	}
+	return mismatches
+}
+
+// alert raises a critical alert for m unless the same state was reported
+func (s *selfChecker) alert(ctx context.Context, m selfcheck.Mismatch) {
+	key := m.Kind + " " + m.Path
+	if m.Kind == selfcheck.KindManifest {
+		key = m.Kind
+	}
+	if s.reported[key] == m.Actual {
+		return
+	}
+	s.reported[key] = m.Actual
+
+	log.Printf("SECURITY: self-check failed: %s", m)
+	alert := &models.Alert{
+		Kind:        models.AlertSelfCheck,
+		UtilityPath: m.Path,
+		Message:     fmt.Sprintf("%s: expected %s, found %s", m.Kind, m.Expected, m.Actual),
+		DetectedAt:  time.Now(),
+		Severity:    "critical",
+	}
+	// Expected and Actual may be "missing", "no overrides" or an error;
+	// only digests go to the checksum fields
+	if isDigest(m.Expected) {
+		alert.OldChecksum = m.Expected
+	}
+	if isDigest(m.Actual) {
+		alert.NewChecksum = m.Actual
+	}
+	if err := s.storage.SaveAlert(alert); err != nil {
+		log.Printf("Failed to save alert: %v", err)
+	}
+	s.notif.SendAlert(ctx, alert)
+}
+
+// isDigest reports whether s is a hex SHA256 digest
+func isDigest(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// run checks at startup and then every interval until ctx is cancelled,
+// reading the manifest again each time so that a re-signed one is adopted
+func (s *selfChecker) run(ctx context.Context, interval time.Duration) {
+	if s.load(ctx) && len(s.check(ctx)) == 0 {
+		log.Printf("Self-check passed against %s", s.manifestPath)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.load(ctx)
+			s.check(ctx)
+		}
+	}
+}
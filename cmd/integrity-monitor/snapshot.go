@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/database"
+	"integrity-monitor/pkg/models"
+)
+
+// runSnapshotCommand manages immutable baseline generations
+func runSnapshotCommand(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  integrity-monitor snapshot list
+  integrity-monitor snapshot create -m "description"
+  integrity-monitor snapshot diff <generation> [generation|current]
+  integrity-monitor snapshot rollback <generation>`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	message := fs.String("m", "", "Snapshot description")
+	fs.Parse(args[1:])
+
+	var storage *database.SQLiteStorage
+	if args[0] == "rollback" {
+		var release func()
+		_, storage, release = openStorageExclusive(*configPath, "snapshot rollback")
+		defer release()
+	} else {
+		_, storage = openStorage(*configPath)
+		defer storage.Close()
+	}
+
+	switch args[0] {
+	case "list":
+		listSnapshots(storage)
+	case "create":
+		if *message == "" {
+			*message = "manual snapshot"
+		}
+		snap, err := storage.CreateSnapshot(*message)
+		if err != nil {
+			log.Fatalf("Failed to create snapshot: %v", err)
+		}
+		fmt.Printf("Created generation %d with %d files\n", snap.ID, snap.FileCount)
+	case "diff":
+		if fs.NArg() < 1 || fs.NArg() > 2 {
+			usage()
+		}
+		to := "current"
+		if fs.NArg() == 2 {
+			to = fs.Arg(1)
+		}
+		diffSnapshots(storage, fs.Arg(0), to)
+	case "rollback":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		id := parseGeneration(fs.Arg(0))
+		snap, err := storage.RollbackToSnapshot(id)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("Active baseline rolled back to generation %d (recorded as generation %d)\n", id, snap.ID)
+	default:
+		usage()
+	}
+}
+
+func listSnapshots(storage *database.SQLiteStorage) {
+	snapshots, err := storage.GetSnapshots()
+	if err != nil {
+		log.Fatalf("Failed to list snapshots: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GENERATION\tCREATED\tFILES\tDESCRIPTION")
+	for _, snap := range snapshots {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", snap.ID, snap.CreatedAt.Format("2006-01-02 15:04:05"),
+			snap.FileCount, snap.Description)
+	}
+	w.Flush()
+}
+
+func diffSnapshots(storage *database.SQLiteStorage, from, to string) {
+	load := func(ref string) []*models.Utility {
+		var utilities []*models.Utility
+		var err error
+		if ref == "current" {
+			utilities, err = storage.GetAllUtilities()
+		} else {
+			utilities, err = storage.GetSnapshotEntries(parseGeneration(ref))
+		}
+		if err != nil {
+			log.Fatalf("Failed to load %s: %v", ref, err)
+		}
+		return utilities
+	}
+
+	printChanges(baseline.Diff(load(from), load(to)))
+}
+
+func printChanges(changes []baseline.Change) {
+	if len(changes) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+
+	for _, change := range changes {
+		switch change.Kind {
+		case models.ChangeAdded:
+			fmt.Printf("+ %s  %s\n", change.Path, change.New.Checksum)
+		case models.ChangeRemoved:
+			fmt.Printf("- %s  %s\n", change.Path, change.Old.Checksum)
+		default:
+			fmt.Printf("~ %s  %s -> %s\n", change.Path, change.Old.Checksum, change.New.Checksum)
+		}
+	}
+	fmt.Printf("%d changes\n", len(changes))
+}
+
+func parseGeneration(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		log.Fatalf("Invalid generation %q", s)
+	}
+	return id
+}
+
+// runHistoryCommand prints the recorded baseline values of one file
+func runHistoryCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	at := fs.String("at", "", "Only show the value in effect at this time (2006-01-02 or 2006-01-02T15:04:05)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: integrity-monitor history [flags] <path>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	_, storage := openStorage(*configPath)
+	defer storage.Close()
+
+	history, err := storage.GetUtilityHistory(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read history: %v", err)
+	}
+
+	if *at != "" {
+		when, err := parseTime(*at)
+		if err != nil {
+			log.Fatalf("Invalid -at value: %v", err)
+		}
+		var effective []*models.HistoryEntry
+		for _, entry := range history {
+			if !entry.RecordedAt.After(when) {
+				effective = []*models.HistoryEntry{entry}
+			}
+		}
+		history = effective
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No history recorded")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORDED\tCHANGE\tCHECKSUM\tSIZE\tMODIFIED")
+	for _, entry := range history {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", entry.RecordedAt.Format("2006-01-02 15:04:05"),
+			entry.Change, entry.Checksum, entry.Size, entry.LastModified.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// parseTime accepts a date or a local date and time; a bare date means the
+// end of that day
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(24*time.Hour - time.Nanosecond), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"integrity-monitor/internal/auditlog"
+)
+
+// runVerifyLogCommand checks the alert hash chain for edits and gaps
+func runVerifyLogCommand(args []string) {
+	fs := flag.NewFlagSet("verify-log", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to configuration file")
+	headFile := fs.String("head", "", "Export file whose last head must still be in the log (defaults to alert_chain.export_file)")
+	fs.Parse(args)
+
+	cfg, storage := openStorage(*configPath)
+	defer storage.Close()
+
+	report, err := auditlog.Verify(storage)
+	if err != nil {
+		log.Fatalf("Failed to verify alert log: %v", err)
+	}
+
+	if *headFile == "" {
+		*headFile = cfg.AlertChain.ExportFile
+	}
+	if *headFile != "" {
+		record, err := auditlog.ReadLastExport(*headFile)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("cannot read exported head: %v", err))
+		} else if record.ID > 0 {
+			if err := auditlog.VerifyHead(storage, record.Head); err != nil {
+				report.Problems = append(report.Problems, err.Error())
+			}
+		}
+	}
+
+	fmt.Printf("Chained records: %d\n", report.Records)
+	if report.Unchained > 0 {
+		fmt.Printf("Records from before chaining: %d\n", report.Unchained)
+	}
+	if report.Head.ID > 0 {
+		fmt.Printf("Head: #%d %s\n", report.Head.ID, report.Head.Hash)
+	}
+
+	if !report.OK() {
+		for _, problem := range report.Problems {
+			fmt.Printf("PROBLEM: %s\n", problem)
+		}
+		fmt.Println("Alert log verification FAILED")
+		os.Exit(1)
+	}
+	fmt.Println("Alert log OK")
+}
@@ -0,0 +1,163 @@
+package auditlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// HashVersion is the version of AlertHash used for new records; version 1
+// did not cover the kind and message of an alert
+const HashVersion = 2
+
+// AlertHash links an alert to its predecessor; editing any hashed field or
+// removing a record breaks every later link
+func AlertHash(prevHash string, alert *models.Alert) string {
+	fields := []string{
+		prevHash,
+		alert.UtilityPath,
+		alert.OldChecksum,
+		alert.NewChecksum,
+		alert.DetectedAt.UTC().Format(time.RFC3339Nano),
+		alert.Severity,
+	}
+	if alert.HashVersion >= 2 {
+		fields = append(fields, strconv.Itoa(alert.HashVersion), alert.Kind, alert.Message)
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s\n", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainSource reads the stored alert chain
+type ChainSource interface {
+	GetAlertChain(afterID int64, limit int) ([]*models.Alert, error)
+	GetAlertSequence() (int64, error)
+}
+
+// Head identifies the newest record of the chain
+type Head struct {
+	ID   int64     `json:"id"`
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// Report is the outcome of verifying the chain
+type Report struct {
+	Records   int      // chained records checked
+	Unchained int      // records written before chaining was enabled
+	Head      Head     // last valid record
+	Problems  []string // every detected inconsistency
+}
+
+// OK reports whether the chain verified without problems
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify walks the whole chain, checking hashes, links and ID continuity
+func Verify(source ChainSource) (*Report, error) {
+	const pageSize = 1000
+
+	report := &Report{}
+	var prev *models.Alert
+	chained := false
+	version := 1
+
+	for afterID := int64(0); ; {
+		alerts, err := source.GetAlertChain(afterID, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, alert := range alerts {
+			expectedID := int64(1)
+			if prev != nil {
+				expectedID = prev.ID + 1
+			}
+			if alert.ID != expectedID {
+				report.problem("records %d-%d are missing", expectedID, alert.ID-1)
+			}
+
+			switch {
+			case alert.Hash == "" && !chained:
+				report.Unchained++
+			case alert.Hash == "":
+				report.problem("record %d has no hash after the chain started", alert.ID)
+			default:
+				expectedPrev := ""
+				if chained {
+					expectedPrev = prev.Hash
+				}
+				if alert.PrevHash != expectedPrev {
+					report.problem("record %d does not link to its predecessor", alert.ID)
+				}
+				if AlertHash(alert.PrevHash, alert) != alert.Hash {
+					report.problem("record %d was modified", alert.ID)
+				}
+				// Version 1 hashes do not cover the kind and message, so
+				// they must not carry any, and a record cannot fall back
+				// to version 1 once a later version was used
+				switch {
+				case alert.HashVersion < version || alert.HashVersion > HashVersion:
+					report.problem("record %d has unexpected hash version %d", alert.ID, alert.HashVersion)
+				case alert.HashVersion == 1 && (alert.Kind != models.AlertMismatch || alert.Message != ""):
+					report.problem("record %d has a kind or message its hash does not cover", alert.ID)
+				}
+				if alert.HashVersion > version {
+					version = alert.HashVersion
+				}
+				chained = true
+				report.Records++
+				report.Head = Head{ID: alert.ID, Hash: alert.Hash, Time: alert.DetectedAt}
+			}
+
+			prev = alert
+		}
+
+		if len(alerts) < pageSize {
+			break
+		}
+		afterID = alerts[len(alerts)-1].ID
+	}
+
+	seq, err := source.GetAlertSequence()
+	if err != nil {
+		return nil, err
+	}
+	last := int64(0)
+	if prev != nil {
+		last = prev.ID
+	}
+	if seq > last {
+		report.problem("records %d-%d were removed from the end of the log", last+1, seq)
+	}
+
+	return report, nil
+}
+
+// VerifyHead checks that a previously exported head is still part of the chain
+func VerifyHead(source ChainSource, head Head) error {
+	alerts, err := source.GetAlertChain(head.ID-1, 1)
+	if err != nil {
+		return err
+	}
+	if len(alerts) == 0 || alerts[0].ID != head.ID {
+		return fmt.Errorf("exported head record %d no longer exists", head.ID)
+	}
+	if alerts[0].Hash != head.Hash {
+		return fmt.Errorf("exported head record %d has hash %s, log has %s", head.ID, head.Hash, alerts[0].Hash)
+	}
+	return nil
+}
+
+func (r *Report) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
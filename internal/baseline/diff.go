@@ -0,0 +1,47 @@
+package baseline
+
+import (
+	"sort"
+
+	"integrity-monitor/pkg/models"
+)
+
+// Change describes how one file differs between two baselines
+type Change struct {
+	Path string
+	Kind string // models.ChangeAdded, ChangeUpdated or ChangeRemoved
+	Old  *models.Utility
+	New  *models.Utility
+}
+
+// Diff compares two baselines by path and checksum, sorted by path
+func Diff(old, new []*models.Utility) []Change {
+	oldByPath := make(map[string]*models.Utility, len(old))
+	for _, util := range old {
+		oldByPath[util.Path] = util
+	}
+
+	var changes []Change
+	seen := make(map[string]bool, len(new))
+	for _, util := range new {
+		seen[util.Path] = true
+		prev, ok := oldByPath[util.Path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: util.Path, Kind: models.ChangeAdded, New: util})
+		case prev.Checksum != util.Checksum:
+			changes = append(changes, Change{Path: util.Path, Kind: models.ChangeUpdated, Old: prev, New: util})
+		}
+	}
+
+	for _, util := range old {
+		if !seen[util.Path] {
+			changes = append(changes, Change{Path: util.Path, Kind: models.ChangeRemoved, Old: util})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
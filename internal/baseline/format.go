@@ -0,0 +1,214 @@
+package baseline
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// Identifiers of the portable baseline format
+const (
+	FormatName      = "integrity-monitor-baseline"
+	FormatVersion   = 1
+	AlgorithmSHA256 = "sha256"
+)
+
+// Document is the portable baseline. Files are sorted by path; MerkleRoot
+// is computed as in MerkleRoot and, when present, Signature is an Ed25519
+// signature over the same message used for signing the database baseline,
+// so a signed export can be verified with the configured public key.
+type Document struct {
+	Format      string     `json:"format"`
+	Version     int        `json:"version"`
+	Algorithm   string     `json:"algorithm"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Host        string     `json:"host"`
+	Description string     `json:"description,omitempty"`
+	MerkleRoot  string     `json:"merkle_root"`
+	Signature   *Signature `json:"signature,omitempty"`
+	Files       []File     `json:"files"`
+}
+
+// Signature carries the signing key fingerprint and the hex signature
+type Signature struct {
+	KeyID string `json:"key_id"`
+	Value string `json:"value"`
+}
+
+// File is one baseline entry; the owning package is informational and not
+// covered by the Merkle root
+type File struct {
+	Path           string    `json:"path"`
+	Digest         string    `json:"digest"`
+	Size           int64     `json:"size"`
+	Modified       time.Time `json:"modified"`
+	Package        string    `json:"package,omitempty"`
+	PackageVersion string    `json:"package_version,omitempty"`
+}
+
+// NewDocument builds a document from baseline entries
+func NewDocument(utilities []*models.Utility, description string) *Document {
+	host, _ := os.Hostname()
+	doc := &Document{
+		Format:      FormatName,
+		Version:     FormatVersion,
+		Algorithm:   AlgorithmSHA256,
+		CreatedAt:   time.Now().UTC(),
+		Host:        host,
+		Description: description,
+		MerkleRoot:  MerkleRoot(utilities),
+	}
+
+	for _, util := range utilities {
+		doc.Files = append(doc.Files, File{
+			Path:           util.Path,
+			Digest:         util.Checksum,
+			Size:           util.Size,
+			Modified:       util.LastModified.UTC(),
+			Package:        util.Package,
+			PackageVersion: util.PackageVersion,
+		})
+	}
+	sort.Slice(doc.Files, func(i, j int) bool {
+		return doc.Files[i].Path < doc.Files[j].Path
+	})
+
+	return doc
+}
+
+// Utilities converts the document back into baseline entries
+func (d *Document) Utilities() []*models.Utility {
+	utilities := make([]*models.Utility, 0, len(d.Files))
+	for _, f := range d.Files {
+		utilities = append(utilities, &models.Utility{
+			Path:           f.Path,
+			Checksum:       f.Digest,
+			Size:           f.Size,
+			LastModified:   f.Modified,
+			Package:        f.Package,
+			PackageVersion: f.PackageVersion,
+		})
+	}
+	return utilities
+}
+
+// Sign attaches a signature over the document's Merkle root
+func (d *Document) Sign(priv ed25519.PrivateKey) {
+	d.Signature = &Signature{
+		KeyID: KeyID(priv.Public().(ed25519.PublicKey)),
+		Value: hex.EncodeToString(ed25519.Sign(priv, signedMessage(d.MerkleRoot))),
+	}
+}
+
+// Validate checks the format header, digests and Merkle root
+func (d *Document) Validate() error {
+	if d.Format != FormatName {
+		return fmt.Errorf("not a baseline document (format %q)", d.Format)
+	}
+	if d.Version != FormatVersion {
+		return fmt.Errorf("unsupported baseline format version %d", d.Version)
+	}
+	if d.Algorithm != AlgorithmSHA256 {
+		return fmt.Errorf("unsupported digest algorithm %q", d.Algorithm)
+	}
+
+	seen := make(map[string]bool, len(d.Files))
+	for _, f := range d.Files {
+		if !strings.HasPrefix(f.Path, "/") {
+			return fmt.Errorf("path %q is not absolute", f.Path)
+		}
+		if seen[f.Path] {
+			return fmt.Errorf("duplicate path %q", f.Path)
+		}
+		seen[f.Path] = true
+		if b, err := hex.DecodeString(f.Digest); err != nil || len(b) != 32 {
+			return fmt.Errorf("invalid sha256 digest for %s", f.Path)
+		}
+	}
+
+	if root := MerkleRoot(d.Utilities()); root != d.MerkleRoot {
+		return fmt.Errorf("merkle root mismatch: document says %s, files give %s", d.MerkleRoot, root)
+	}
+	return nil
+}
+
+// VerifySignature checks the document signature against pub
+func (d *Document) VerifySignature(pub ed25519.PublicKey) error {
+	if d.Signature == nil {
+		return ErrUnsigned
+	}
+	if d.Signature.KeyID != KeyID(pub) {
+		return fmt.Errorf("document signed with unknown key %s (expected %s)", d.Signature.KeyID, KeyID(pub))
+	}
+	sig, err := hex.DecodeString(d.Signature.Value)
+	if err != nil || !ed25519.Verify(pub, signedMessage(d.MerkleRoot), sig) {
+		return fmt.Errorf("document signature is invalid")
+	}
+	return nil
+}
+
+// WriteDocument encodes the document as indented JSON, gzip-compressed
+// when the path ends in .gz
+func WriteDocument(path string, doc *Document) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// ReadDocument decodes a document, detecting gzip compression by content
+func ReadDocument(path string) (*Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,70 @@
+package baseline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"integrity-monitor/internal/health"
+	"integrity-monitor/pkg/models"
+)
+
+// beatEvery is how many nodes are hashed between progress beats
+const beatEvery = 1000
+
+// MerkleRoot hashes the baseline into a single digest. Leaves cover the
+// path and checksum of each file in path order; timestamps and sizes are
+// left out because the comparator refreshes them without changing content.
+func MerkleRoot(utilities []*models.Utility) string {
+	return merkleRoot(context.Background(), utilities)
+}
+
+// merkleRoot is MerkleRoot beating ctx while it hashes a large baseline
+func merkleRoot(ctx context.Context, utilities []*models.Utility) string {
+	sorted := make([]*models.Utility, len(utilities))
+	copy(sorted, utilities)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	level := make([][]byte, 0, len(sorted))
+	for i, util := range sorted {
+		if i%beatEvery == 0 {
+			health.Beat(ctx)
+		}
+		h := sha256.New()
+		h.Write([]byte{0x00})
+		h.Write([]byte(util.Path))
+		h.Write([]byte{0x00})
+		h.Write([]byte(util.Checksum))
+		level = append(level, h.Sum(nil))
+	}
+
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i%beatEvery == 0 {
+				health.Beat(ctx)
+			}
+			if i+1 == len(level) {
+				// Odd node is promoted unchanged
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{0x01})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0])
+}
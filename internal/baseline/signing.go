@@ -0,0 +1,168 @@
+package baseline
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/health"
+	"integrity-monitor/pkg/models"
+)
+
+// ErrUnsigned is returned when the baseline has no signature at all
+var ErrUnsigned = errors.New("baseline is not signed")
+
+// GenerateKey writes a new Ed25519 key pair as PKCS#8 / PKIX PEM files
+func GenerateKey(privatePath, publicPath string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644)
+}
+
+// LoadPrivateKey reads a PEM encoded Ed25519 private key
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+	return block, nil
+}
+
+// KeyID is a short fingerprint identifying a public key
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signedMessage binds the root to its purpose so the key cannot be abused
+// to sign other data
+func signedMessage(root string) []byte {
+	return []byte("integrity-monitor baseline v1\n" + root)
+}
+
+// SignBaseline signs the current baseline and stores the signature
+func SignBaseline(storage database.Storage, priv ed25519.PrivateKey) (*models.BaselineSignature, error) {
+	utilities, err := storage.GetAllUtilities()
+	if err != nil {
+		return nil, err
+	}
+
+	root := MerkleRoot(utilities)
+	sig := &models.BaselineSignature{
+		MerkleRoot: root,
+		Signature:  hex.EncodeToString(ed25519.Sign(priv, signedMessage(root))),
+		KeyID:      KeyID(priv.Public().(ed25519.PublicKey)),
+		FileCount:  len(utilities),
+		SignedAt:   time.Now(),
+	}
+
+	if err := storage.SaveBaselineSignature(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Verifier checks that the stored baseline matches its signature
+type Verifier struct {
+	storage database.Storage
+	pub     ed25519.PublicKey
+}
+
+func NewVerifier(storage database.Storage, pub ed25519.PublicKey) *Verifier {
+	return &Verifier{storage: storage, pub: pub}
+}
+
+// Verify recomputes the Merkle root of the baseline and checks it against
+// the latest signature, beating ctx as it goes
+func (v *Verifier) Verify(ctx context.Context) (*models.BaselineSignature, error) {
+	sig, err := v.storage.GetBaselineSignature()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline signature: %w", err)
+	}
+	if sig == nil {
+		return nil, ErrUnsigned
+	}
+
+	if sig.KeyID != KeyID(v.pub) {
+		return sig, fmt.Errorf("baseline signed with unknown key %s (expected %s)", sig.KeyID, KeyID(v.pub))
+	}
+
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(v.pub, signedMessage(sig.MerkleRoot), signature) {
+		return sig, fmt.Errorf("baseline signature is invalid")
+	}
+
+	health.Beat(ctx)
+	utilities, err := v.storage.GetAllUtilities()
+	if err != nil {
+		return sig, fmt.Errorf("failed to read baseline: %w", err)
+	}
+
+	if root := merkleRoot(ctx, utilities); root != sig.MerkleRoot {
+		return sig, fmt.Errorf("baseline does not match its signature (signed root %s, current root %s)",
+			sig.MerkleRoot, root)
+	}
+
+	return sig, nil
+}
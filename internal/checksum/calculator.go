@@ -1,15 +1,23 @@
 package checksum
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+
+	"integrity-monitor/internal/health"
+	"integrity-monitor/internal/metrics"
 )
 
-// CalculateSHA256 computes the SHA256 checksum of a file
-func CalculateSHA256(filePath string) (string, error) {
+// beatEvery is how many bytes are hashed between progress beats
+const beatEvery = 1 << 20
+
+// CalculateSHA256 computes the SHA256 checksum of a file; it stops early
+// when ctx is cancelled
+func CalculateSHA256(ctx context.Context, filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file: %w", err)
@@ -17,9 +25,33 @@ func CalculateSHA256(filePath string) (string, error) {
 	defer file.Close()
 
 	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	n, err := io.Copy(hash, &contextReader{ctx: ctx, r: file})
+	metrics.BytesHashed.Add(float64(n))
+	if err != nil {
 		return "", fmt.Errorf("failed to calculate hash: %w", err)
 	}
+	metrics.FilesHashed.Inc()
 
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
+
+// contextReader fails reads once ctx is done, so that hashing a large file
+// does not hold up shutdown, and beats every beatEvery bytes, so that it is
+// not taken for a stall either
+type contextReader struct {
+	ctx    context.Context
+	r      io.Reader
+	unbeat int
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	if c.unbeat += n; c.unbeat >= beatEvery {
+		health.Beat(c.ctx)
+		c.unbeat = 0
+	}
+	return n, err
+}
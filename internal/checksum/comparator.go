@@ -1,33 +1,89 @@
 package checksum
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/packages"
+	"integrity-monitor/internal/rootfs"
 	"integrity-monitor/pkg/models"
 )
 
 type Comparator struct {
-	storage database.Storage
+	storage     database.Storage
+	root        rootfs.Root
+	packages    *packages.Database
+	maintenance *packages.Maintenance
+	signed      bool
+	notif       notifier.Notifier
+
+	// packageMu keeps the scan and the watcher from both starting a
+	// window for package updates or alerting the same overdue lock
+	packageMu sync.Mutex
+	overdue   time.Time // start of the last deferral alerted as overdue
+
+	// windows holds the open maintenance windows, loaded once per scan by
+	// LoadMaintenanceWindows rather than once per file
+	windowMu sync.Mutex
+	windows  []*models.MaintenanceWindow
+	loaded   bool
+}
+
+// packageApprovalPeriod is how long a window of package updates awaiting
+// approval into a signed baseline stays open before a new one is started
+const packageApprovalPeriod = 7 * 24 * time.Hour
+
+// NewComparator checks files of the system found at root against the
+// baseline, which is keyed by that system's own paths
+func NewComparator(storage database.Storage, root rootfs.Root) *Comparator {
+	return &Comparator{storage: storage, root: root}
+}
+
+// SetMaintenance lets updates made by the package manager through without
+// a critical alert
+func (c *Comparator) SetMaintenance(m *packages.Maintenance) {
+	c.maintenance = m
+}
+
+// SetSignedBaseline keeps the comparator from approving package updates
+// into a baseline signed offline, which would no longer match its
+// signature; they are queued for approval in a maintenance window instead
+func (c *Comparator) SetSignedBaseline(signed bool) {
+	c.signed = signed
+}
+
+// SetNotifier delivers the alerts the comparator raises besides the ones
+// it returns for checked files
+func (c *Comparator) SetNotifier(n notifier.Notifier) {
+	c.notif = n
 }
 
-func NewComparator(storage database.Storage) *Comparator {
-	return &Comparator{storage: storage}
+// SetPackages makes StoreChecksum record the package owning each file
+func (c *Comparator) SetPackages(db *packages.Database) {
+	c.packages = db
 }
 
 // CheckFile verifies if a file's checksum matches the stored value
-func (c *Comparator) CheckFile(filePath string) (*models.Alert, error) {
+func (c *Comparator) CheckFile(ctx context.Context, filePath string) (*models.Alert, error) {
+	hostPath, err := c.root.Resolve(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve file: %w", err)
+	}
+
 	// Get file info
-	fileInfo, err := os.Stat(filePath)
+	fileInfo, err := os.Stat(hostPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
 	// Calculate current checksum
-	currentChecksum, err := CalculateSHA256(filePath)
+	currentChecksum, err := CalculateSHA256(ctx, hostPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate checksum: %w", err)
 	}
@@ -41,11 +97,16 @@ func (c *Comparator) CheckFile(filePath string) (*models.Alert, error) {
 	// If no stored checksum, this is a new file
 	if storedUtil == nil {
 		log.Printf("New utility detected: %s", filePath)
+		c.queueInMaintenance(filePath, "", currentChecksum)
 		return nil, nil
 	}
 
 	// Compare checksums
 	if storedUtil.Checksum != currentChecksum {
+		if c.queueInMaintenance(filePath, storedUtil.Checksum, currentChecksum) {
+			return nil, nil
+		}
+
 		alert := &models.Alert{
 			UtilityPath: filePath,
 			OldChecksum: storedUtil.Checksum,
@@ -54,9 +115,54 @@ func (c *Comparator) CheckFile(filePath string) (*models.Alert, error) {
 			Severity:    "critical",
 		}
 
-		// Save alert
+		if c.maintenance != nil {
+			// Only files of a package can be half-installed; anything else
+			// is checked even while the package manager runs
+			if holder := c.maintenance.Holder(); holder != nil && c.maintenance.Owner(filePath) != nil {
+				if time.Since(holder.Since) < c.maintenance.MaxDefer() {
+					// Half-installed files are checked again by the next scan
+					log.Printf("Package manager is running, deferring check of %s", filePath)
+					return nil, nil
+				}
+				c.alertOverdueLock(ctx, holder)
+			}
+			if owner, ok := c.maintenance.Installed(filePath, storedUtil.UpdatedAt); ok {
+				// The package logs and digests are as writable by root as
+				// the file itself, so an update is never accepted silently
+				alert.Kind = models.AlertPackageUpdate
+				alert.Severity = "medium"
+				if c.signed {
+					// Queued until an operator approves and re-signs;
+					// alerted once
+					queued, err := c.queuePackageUpdate(filePath, storedUtil.Checksum, currentChecksum, owner)
+					if err != nil {
+						return nil, err
+					}
+					if !queued {
+						return nil, nil
+					}
+					alert.Message = fmt.Sprintf("installed by %s %s, awaiting approval into the signed baseline", owner.Package, owner.Version)
+				} else {
+					log.Printf("%s was updated by package %s %s and matches it, approving", filePath, owner.Package, owner.Version)
+					if err := c.storage.SaveUtility(&models.Utility{
+						Path:           filePath,
+						Checksum:       currentChecksum,
+						LastModified:   fileInfo.ModTime(),
+						Size:           fileInfo.Size(),
+						Package:        owner.Package,
+						PackageVersion: owner.Version,
+					}); err != nil {
+						return nil, fmt.Errorf("failed to approve package update: %w", err)
+					}
+					alert.Message = fmt.Sprintf("installed by %s %s, approved into the baseline", owner.Package, owner.Version)
+				}
+			}
+		}
+
+		// Save alert; one that could not be saved is still returned, and the
+		// dispatcher delivers it directly since it is not in the outbox
 		if err := c.storage.SaveAlert(alert); err != nil {
-			log.Printf("Failed to save alert: %v", err)
+			log.Printf("Failed to save alert for %s: %v", filePath, err)
 		}
 
 		return alert, nil
@@ -65,10 +171,12 @@ func (c *Comparator) CheckFile(filePath string) (*models.Alert, error) {
 	// Update last modified time if file changed but checksum is same
 	if !fileInfo.ModTime().Equal(storedUtil.LastModified) {
 		updatedUtil := &models.Utility{
-			Path:         filePath,
-			Checksum:     currentChecksum,
-			LastModified: fileInfo.ModTime(),
-			Size:         fileInfo.Size(),
+			Path:           filePath,
+			Checksum:       currentChecksum,
+			LastModified:   fileInfo.ModTime(),
+			Size:           fileInfo.Size(),
+			Package:        storedUtil.Package,
+			PackageVersion: storedUtil.PackageVersion,
 		}
 		c.storage.SaveUtility(updatedUtil)
 	}
@@ -76,14 +184,195 @@ func (c *Comparator) CheckFile(filePath string) (*models.Alert, error) {
 	return nil, nil
 }
 
+// CheckFileQuick hashes the file only if its size or modification time
+// differ from the baseline. It is cheap enough to run often but misses a
+// replacement that kept both, so it complements CheckFile rather than
+// replacing it.
+func (c *Comparator) CheckFileQuick(ctx context.Context, filePath string) (*models.Alert, error) {
+	hostPath, err := c.root.Resolve(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve file: %w", err)
+	}
+
+	fileInfo, err := os.Stat(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	storedUtil, err := c.storage.GetUtility(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stored utility: %w", err)
+	}
+	if storedUtil != nil && storedUtil.Size == fileInfo.Size() && storedUtil.LastModified.Equal(fileInfo.ModTime()) {
+		return nil, nil
+	}
+
+	return c.CheckFile(ctx, filePath)
+}
+
+// LoadMaintenanceWindows reads the open maintenance windows that CheckFile
+// consults. Call it before each scan and whenever windows are started or
+// ended; until then the first check loads them.
+func (c *Comparator) LoadMaintenanceWindows() error {
+	windows, err := c.storage.GetMaintenanceWindows(true, -1)
+	if err != nil {
+		return err
+	}
+	c.windowMu.Lock()
+	c.windows, c.loaded = windows, true
+	c.windowMu.Unlock()
+	return nil
+}
+
+// activeWindow returns the first active window that match accepts, or nil
+func (c *Comparator) activeWindow(match func(w *models.MaintenanceWindow) bool) (*models.MaintenanceWindow, error) {
+	c.windowMu.Lock()
+	loaded := c.loaded
+	c.windowMu.Unlock()
+	if !loaded {
+		if err := c.LoadMaintenanceWindows(); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	c.windowMu.Lock()
+	defer c.windowMu.Unlock()
+	for _, w := range c.windows {
+		if w.Active(now) && match(w) {
+			return w, nil
+		}
+	}
+	return nil, nil
+}
+
+// queueInMaintenance records the change in an active maintenance window
+// covering filePath and reports whether it did so
+func (c *Comparator) queueInMaintenance(filePath, oldChecksum, newChecksum string) bool {
+	window, err := c.activeWindow(func(w *models.MaintenanceWindow) bool {
+		return w.Kind == models.MaintenanceManual && w.Covers(filePath)
+	})
+	if err != nil {
+		log.Printf("Failed to check maintenance windows: %v", err)
+		return false
+	}
+	if window == nil {
+		return false
+	}
+
+	err = c.storage.RecordMaintenanceChange(&models.MaintenanceChange{
+		WindowID:    window.ID,
+		Path:        filePath,
+		OldChecksum: oldChecksum,
+		NewChecksum: newChecksum,
+		DetectedAt:  time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to queue change of %s in maintenance window %d: %v", filePath, window.ID, err)
+		return false
+	}
+	log.Printf("Change of %s queued for approval in maintenance window %d (%s)", filePath, window.ID, window.Reason)
+	return true
+}
+
+// alertOverdueLock reports, once per deferral, package manager locks held
+// for longer than checks may be deferred; they are made as usual from then on
+func (c *Comparator) alertOverdueLock(ctx context.Context, holder *packages.LockHolder) {
+	c.packageMu.Lock()
+	defer c.packageMu.Unlock()
+
+	if c.overdue.Equal(holder.Since) {
+		return
+	}
+	c.overdue = holder.Since
+
+	message := fmt.Sprintf("held since %s, now by pid %d, longer than max_defer %s",
+		holder.Since.Format("2006-01-02 15:04:05"), holder.PID, c.maintenance.MaxDefer())
+	log.Printf("SECURITY: %s %s; checking changed files anyway", holder.Path, message)
+	alert := &models.Alert{
+		Kind:        models.AlertLockOverdue,
+		UtilityPath: holder.Path,
+		Message:     message,
+		DetectedAt:  time.Now(),
+		Severity:    "medium",
+	}
+	if err := c.storage.SaveAlert(alert); err != nil {
+		log.Printf("Failed to save alert for %s: %v", holder.Path, err)
+	}
+	if c.notif != nil {
+		c.notif.SendAlert(ctx, alert)
+	}
+}
+
+// queuePackageUpdate records a verified package update in the open window
+// of package updates, starting one if needed, and reports whether the
+// change was not queued there yet
+func (c *Comparator) queuePackageUpdate(filePath, oldChecksum, newChecksum string, owner *packages.Owner) (bool, error) {
+	c.packageMu.Lock()
+	defer c.packageMu.Unlock()
+
+	now := time.Now()
+	window, err := c.activeWindow(func(w *models.MaintenanceWindow) bool {
+		return w.Kind == models.MaintenancePackages
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check maintenance windows: %w", err)
+	}
+	if window == nil {
+		window = &models.MaintenanceWindow{
+			Kind:      models.MaintenancePackages,
+			Reason:    "package updates awaiting approval into the signed baseline",
+			StartedBy: "integrity-monitor",
+			StartedAt: now,
+			EndsAt:    now.Add(packageApprovalPeriod),
+		}
+		if err := c.storage.StartMaintenance(window); err != nil {
+			return false, fmt.Errorf("failed to start package update window: %w", err)
+		}
+		log.Printf("Maintenance window %d opened for package updates awaiting approval", window.ID)
+		c.windowMu.Lock()
+		c.windows = append(c.windows, window)
+		c.windowMu.Unlock()
+	}
+
+	changes, err := c.storage.GetMaintenanceChanges(window.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read queued changes: %w", err)
+	}
+	for _, change := range changes {
+		if change.Path == filePath && change.NewChecksum == newChecksum {
+			return false, nil
+		}
+	}
+
+	err = c.storage.RecordMaintenanceChange(&models.MaintenanceChange{
+		WindowID:    window.ID,
+		Path:        filePath,
+		OldChecksum: oldChecksum,
+		NewChecksum: newChecksum,
+		DetectedAt:  now,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to queue package update of %s: %w", filePath, err)
+	}
+	log.Printf("%s was updated by package %s %s and matches it; queued for approval into the signed baseline in maintenance window %d",
+		filePath, owner.Package, owner.Version, window.ID)
+	return true, nil
+}
+
 // StoreChecksum stores or updates a utility's checksum in the database
-func (c *Comparator) StoreChecksum(filePath string) error {
-	fileInfo, err := os.Stat(filePath)
+func (c *Comparator) StoreChecksum(ctx context.Context, filePath string) error {
+	hostPath, err := c.root.Resolve(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve file: %w", err)
+	}
+
+	fileInfo, err := os.Stat(hostPath)
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	checksum, err := CalculateSHA256(filePath)
+	checksum, err := CalculateSHA256(ctx, hostPath)
 	if err != nil {
 		return fmt.Errorf("failed to calculate checksum: %w", err)
 	}
@@ -94,6 +383,12 @@ func (c *Comparator) StoreChecksum(filePath string) error {
 		LastModified: fileInfo.ModTime(),
 		Size:         fileInfo.Size(),
 	}
+	if c.packages != nil {
+		if owner := c.packages.Owner(filePath); owner != nil {
+			util.Package = owner.Package
+			util.PackageVersion = owner.Version
+		}
+	}
 
 	return c.storage.SaveUtility(util)
 }
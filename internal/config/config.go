@@ -1,36 +1,502 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"integrity-monitor/internal/rootfs"
+	"integrity-monitor/internal/schedule"
 )
 
 type Config struct {
-	Database       DatabaseConfig `yaml:"database"`
-	MonitoredPaths []string       `yaml:"monitored_paths"`
-	ScanInterval   int            `yaml:"scan_interval"` // seconds
-	EnableWatcher  bool           `yaml:"enable_watcher"`
-	LogFile        string         `yaml:"log_file"`
+	Database       DatabaseConfig   `yaml:"database"`
+	MonitoredPaths []string         `yaml:"monitored_paths"`
+	ScanInterval   Duration         `yaml:"scan_interval"` // deep scan period when no schedules are set
+	ScanJitter     Duration         `yaml:"scan_jitter"`   // random delay added to each scan_interval scan
+	Schedules      []ScheduleConfig `yaml:"schedules"`
+	// ShutdownTimeout bounds how long stopping waits for a scan in
+	// progress and for queued alerts to be delivered
+	ShutdownTimeout Duration         `yaml:"shutdown_timeout"`
+	PIDFile         string           `yaml:"pid_file"` // written by the daemon; empty disables
+	EnableWatcher   bool             `yaml:"enable_watcher"`
+	LogFile         string           `yaml:"log_file"`
+	Notifiers       NotifiersConfig  `yaml:"notifiers"`
+	Outbox          OutboxConfig     `yaml:"outbox"`
+	Baseline        BaselineConfig   `yaml:"baseline"`
+	SelfCheck       SelfCheckConfig  `yaml:"self_check"`
+	AlertChain      AlertChainConfig `yaml:"alert_chain"`
+	Packages        PackagesConfig   `yaml:"packages"`
+	Control         ControlConfig    `yaml:"control"`
+	Metrics         MetricsConfig    `yaml:"metrics"`
+	Health          HealthConfig     `yaml:"health"`
 }
 
 type DatabaseConfig struct {
 	Path string `yaml:"path"`
+	// AutoMigrate applies pending schema migrations when the daemon, -init
+	// or -scan start; otherwise they refuse to run until `db migrate` did
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+type NotifiersConfig struct {
+	Locale    string            `yaml:"locale"`    // built-in message catalog: en, ru
+	Templates map[string]string `yaml:"templates"` // message name -> Go text/template override
+	TTY       TTYConfig         `yaml:"tty"`
+	Webhooks  []WebhookConfig   `yaml:"webhooks"`
+	Email     EmailConfig       `yaml:"email"`
+}
+
+type TTYConfig struct {
+	UtmpFile string   `yaml:"utmp_file"`
+	Groups   []string `yaml:"groups"` // only users in these groups receive alerts; empty = all
+}
+
+type WebhookConfig struct {
+	Name            string            `yaml:"name"` // outbox channel name, defaults to webhook-<index>
+	URL             string            `yaml:"url"`
+	Method          string            `yaml:"method"`
+	Headers         map[string]string `yaml:"headers"`
+	BodyTemplate    string            `yaml:"body_template"`
+	Secret          string            `yaml:"secret"`
+	SignatureHeader string            `yaml:"signature_header"`
+	Timeout         Duration          `yaml:"timeout"`
+	MaxRetries      int               `yaml:"max_retries"`
+	RetryBackoff    Duration          `yaml:"retry_backoff"` // doubled after each attempt
+}
+
+type EmailConfig struct {
+	Enabled    bool                `yaml:"enabled"`
+	Host       string              `yaml:"host"`
+	Port       int                 `yaml:"port"`
+	Username   string              `yaml:"username"`
+	Password   string              `yaml:"password"`
+	From       string              `yaml:"from"`
+	StartTLS   bool                `yaml:"starttls"`
+	Recipients map[string][]string `yaml:"recipients"` // severity -> addresses, "default" for the rest
+	Digest     bool                `yaml:"digest"`
+	// ImmediateSeverities are sent as soon as they are raised instead of
+	// joining the digest
+	ImmediateSeverities []string `yaml:"immediate_severities"`
+	Timeout             Duration `yaml:"timeout"`
+}
+
+// BaselineConfig enables verification of the signed baseline
+type BaselineConfig struct {
+	PublicKey string `yaml:"public_key"` // Ed25519 PEM; when set, an unsigned or altered baseline is rejected
+}
+
+// SelfCheckConfig verifies the monitor's own executable, configuration and
+// baseline against a manifest signed with the baseline key
+type SelfCheckConfig struct {
+	Manifest string   `yaml:"manifest"` // written by "integrity-monitor self sign"; empty disables
+	Interval Duration `yaml:"interval"`
+}
+
+// AlertChainConfig publishes the head of the hash-chained alert log
+type AlertChainConfig struct {
+	ExportFile     string   `yaml:"export_file"` // append-only or write-once location
+	ExportURL      string   `yaml:"export_url"`  // remote collector receiving JSON heads
+	ExportInterval Duration `yaml:"export_interval"`
+}
+
+// PackagesConfig cross-checks utilities against the package manager database
+type PackagesConfig struct {
+	Verify      bool     `yaml:"verify"`      // check -init against dpkg/rpm digests and record owning packages
+	Maintenance string   `yaml:"maintenance"` // off or downgrade alerts for verified package updates
+	Locks       []string `yaml:"locks"`       // package manager lock files
+	Logs        []string `yaml:"logs"`        // dpkg.log / apt history.log files
+	MaxDefer    Duration `yaml:"max_defer"`   // longest a held package manager lock defers checks
+}
+
+// ControlConfig sets up the daemon's local control socket
+type ControlConfig struct {
+	Socket string `yaml:"socket"` // Unix socket path; empty disables the control API
+	Group  string `yaml:"group"`  // members may query status and alerts; root may do anything
+}
+
+// MetricsConfig exposes Prometheus metrics over HTTP
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // e.g. 127.0.0.1:9464; empty disables the listener
+}
+
+// HealthConfig decides when a loop that stopped making progress is hung
+type HealthConfig struct {
+	StallTimeout Duration `yaml:"stall_timeout"` // time without a heartbeat
+}
+
+// Scan modes of a schedule
+const (
+	ScanDeep  = "deep"  // hash every file
+	ScanQuick = "quick" // hash only files whose size or mtime differ from the baseline
+)
+
+// ScheduleConfig runs full scans at fixed times or intervals
+type ScheduleConfig struct {
+	Name   string   `yaml:"name"`   // defaults to schedule-<index>
+	Cron   string   `yaml:"cron"`   // "0 3 * * *", @daily, "@every 5m", ...
+	Every  Duration `yaml:"every"`  // alternative to cron
+	Mode   string   `yaml:"mode"`   // deep (default) or quick
+	Jitter Duration `yaml:"jitter"` // random delay added to each run
+}
+
+// Schedule returns when the entry runs
+func (s ScheduleConfig) Schedule() (schedule.Schedule, error) {
+	switch {
+	case s.Cron != "" && s.Every != 0:
+		return nil, fmt.Errorf("set either cron or every, not both")
+	case s.Cron != "":
+		return schedule.Parse(s.Cron)
+	case s.Every > 0:
+		return schedule.Every(time.Duration(s.Every)), nil
+	case s.Every < 0:
+		return nil, fmt.Errorf("every must be positive, got %s", s.Every)
+	default:
+		return nil, fmt.Errorf("cron or every is required")
+	}
+}
+
+// ScanSchedules returns the configured schedules with their defaults
+// filled in, or a single deep scan every scan_interval if there are none
+func (c *Config) ScanSchedules() []ScheduleConfig {
+	if len(c.Schedules) == 0 {
+		return []ScheduleConfig{{Name: "scan_interval", Every: c.ScanInterval, Mode: ScanDeep, Jitter: c.ScanJitter}}
+	}
+	schedules := make([]ScheduleConfig, len(c.Schedules))
+	for i, s := range c.Schedules {
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("schedule-%d", i)
+		}
+		if s.Mode == "" {
+			s.Mode = ScanDeep
+		}
+		schedules[i] = s
+	}
+	return schedules
+}
+
+// Duration is written as a Go duration string ("90s", "5m", "1h30m") or,
+// as in older configuration files, as a whole number of seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		if seconds, err := strconv.ParseInt(value.Value, 10, 64); err == nil {
+			*d = Duration(time.Duration(seconds) * time.Second)
+			return nil
+		}
+		if parsed, err := time.ParseDuration(value.Value); err == nil {
+			*d = Duration(parsed)
+			return nil
+		}
+	}
+	// A TypeError is collected with the other problems of the file
+	return &yaml.TypeError{Errors: []string{
+		fmt.Sprintf("line %d: invalid duration %q, use e.g. 30s, 5m or 1h30m", value.Line, value.Value),
+	}}
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// OutboxConfig controls redelivery of alerts that a notifier failed to send
+type OutboxConfig struct {
+	PollInterval Duration `yaml:"poll_interval"`
+	RetryBackoff Duration `yaml:"retry_backoff"` // doubled after each attempt
+	MaxBackoff   Duration `yaml:"max_backoff"`
+	MaxAttempts  int      `yaml:"max_attempts"` // 0 retries forever
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validate rejects settings the daemon cannot run with and reports all of
+// them at once. Monitored paths are looked up under root; the database,
+// log and key files always live on the host.
+func (c *Config) Validate(root rootfs.Root) error {
+	v := &validator{}
+
+	if c.Database.Path == "" {
+		v.add("database.path is required")
+	} else if v.absolute("database.path", c.Database.Path) {
+		v.writableDir("database.path", filepath.Dir(c.Database.Path))
+	}
+
+	if len(c.MonitoredPaths) == 0 {
+		v.add("monitored_paths is empty")
+	}
+	for _, path := range c.MonitoredPaths {
+		if !v.absolute("monitored_paths", path) {
+			continue
+		}
+		if _, err := root.Stat(path); err != nil {
+			v.add("monitored_paths: %s: %v", path, unwrapPathError(err))
+		}
+	}
+
+	if len(c.Schedules) == 0 {
+		v.positiveDuration("scan_interval", c.ScanInterval)
+		v.notNegativeDuration("scan_jitter", c.ScanJitter)
+	} else {
+		c.validateSchedules(v)
+	}
+
+	if c.LogFile != "" && v.absolute("log_file", c.LogFile) {
+		v.writableDir("log_file", filepath.Dir(c.LogFile))
+	}
+
+	c.validateNotifiers(v)
+
+	v.positiveDuration("outbox.poll_interval", c.Outbox.PollInterval)
+	v.positiveDuration("outbox.retry_backoff", c.Outbox.RetryBackoff)
+	if c.Outbox.MaxBackoff < c.Outbox.RetryBackoff {
+		v.add("outbox.max_backoff must be at least outbox.retry_backoff (%s), got %s", c.Outbox.RetryBackoff, c.Outbox.MaxBackoff)
+	}
+	v.notNegative("outbox.max_attempts", c.Outbox.MaxAttempts)
+
+	if key := c.Baseline.PublicKey; key != "" && v.absolute("baseline.public_key", key) {
+		if _, err := os.Stat(key); err != nil {
+			v.add("baseline.public_key: %s: %v", key, unwrapPathError(err))
+		}
+	}
+
+	if c.SelfCheck.Manifest != "" {
+		v.absolute("self_check.manifest", c.SelfCheck.Manifest)
+		if c.Baseline.PublicKey == "" {
+			v.add("self_check.manifest requires baseline.public_key to verify its signature")
+		}
+		v.positiveDuration("self_check.interval", c.SelfCheck.Interval)
+	}
+
+	if c.AlertChain.ExportFile != "" || c.AlertChain.ExportURL != "" {
+		v.positiveDuration("alert_chain.export_interval", c.AlertChain.ExportInterval)
+	}
+	if c.AlertChain.ExportFile != "" && v.absolute("alert_chain.export_file", c.AlertChain.ExportFile) {
+		v.writableDir("alert_chain.export_file", filepath.Dir(c.AlertChain.ExportFile))
+	}
+	if c.AlertChain.ExportURL != "" {
+		v.httpURL("alert_chain.export_url", c.AlertChain.ExportURL)
+	}
+
+	switch c.Packages.Maintenance {
+	case "", "off", "downgrade":
+	case "suppress":
+		v.add("packages.maintenance: suppress is no longer supported, package updates always raise a medium alert; use downgrade")
+	default:
+		v.add("packages.maintenance must be off or downgrade, got %q", c.Packages.Maintenance)
+	}
+	if c.Packages.Maintenance != "" && c.Packages.Maintenance != "off" {
+		v.positiveDuration("packages.max_defer", c.Packages.MaxDefer)
+	}
+	for _, path := range c.Packages.Locks {
+		v.absolute("packages.locks", path)
+	}
+	for _, path := range c.Packages.Logs {
+		v.absolute("packages.logs", path)
+	}
+
+	if c.Control.Socket != "" {
+		v.absolute("control.socket", c.Control.Socket)
+	}
+	if c.Control.Group != "" {
+		if _, err := user.LookupGroup(c.Control.Group); err != nil {
+			v.add("control.group: %v", err)
+		}
+	}
+
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			v.add("metrics.listen: %v", err)
+		}
+	}
+
+	v.positiveDuration("health.stall_timeout", c.Health.StallTimeout)
+	v.positiveDuration("shutdown_timeout", c.ShutdownTimeout)
+	if c.PIDFile != "" {
+		v.absolute("pid_file", c.PIDFile)
+	}
+
+	return v.err()
+}
+
+func (c *Config) validateSchedules(v *validator) {
+	names := make(map[string]bool)
+	for i, s := range c.ScanSchedules() {
+		field := fmt.Sprintf("schedules[%d]", i)
+		if names[s.Name] {
+			v.add("%s.name: %q is already used", field, s.Name)
+		}
+		names[s.Name] = true
+		if _, err := s.Schedule(); err != nil {
+			v.add("%s: %v", field, err)
+		}
+		switch s.Mode {
+		case ScanDeep, ScanQuick:
+		default:
+			v.add("%s.mode must be deep or quick, got %q", field, s.Mode)
+		}
+		v.notNegativeDuration(field+".jitter", s.Jitter)
+	}
+}
+
+func (c *Config) validateNotifiers(v *validator) {
+	switch c.Notifiers.Locale {
+	case "", "en", "ru":
+	default:
+		v.add("notifiers.locale must be en or ru, got %q", c.Notifiers.Locale)
+	}
+
+	if c.Notifiers.TTY.UtmpFile != "" {
+		v.absolute("notifiers.tty.utmp_file", c.Notifiers.TTY.UtmpFile)
+	}
+
+	names := map[string]bool{"tty": true, "email": true}
+	for i, wh := range c.Notifiers.Webhooks {
+		field := fmt.Sprintf("notifiers.webhooks[%d]", i)
+		if wh.Name != "" {
+			if names[wh.Name] {
+				v.add("%s.name: channel %q is already used", field, wh.Name)
+			}
+			names[wh.Name] = true
+		}
+		if wh.URL == "" {
+			v.add("%s.url is required", field)
+		} else {
+			v.httpURL(field+".url", wh.URL)
+		}
+		v.notNegativeDuration(field+".timeout", wh.Timeout)
+		v.notNegative(field+".max_retries", wh.MaxRetries)
+		v.notNegativeDuration(field+".retry_backoff", wh.RetryBackoff)
+	}
+
+	email := c.Notifiers.Email
+	if !email.Enabled {
+		return
+	}
+	if email.Host == "" {
+		v.add("notifiers.email.host is required when email is enabled")
+	}
+	if email.Port <= 0 || email.Port > 65535 {
+		v.add("notifiers.email.port must be between 1 and 65535, got %d", email.Port)
+	}
+	if email.From == "" {
+		v.add("notifiers.email.from is required when email is enabled")
+	}
+	recipients := 0
+	for _, addrs := range email.Recipients {
+		recipients += len(addrs)
+	}
+	if recipients == 0 {
+		v.add("notifiers.email.recipients is empty")
+	}
+	for _, severity := range email.ImmediateSeverities {
+		if severity == "" {
+			v.add("notifiers.email.immediate_severities has an empty entry")
+		}
+	}
+	v.notNegativeDuration("notifiers.email.timeout", email.Timeout)
 }
 
-func Load(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
+// validator accumulates problems so that they are reported together
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+func (v *validator) notNegative(field string, value int) {
+	if value < 0 {
+		v.add("%s must not be negative, got %d", field, value)
+	}
+}
+
+func (v *validator) positiveDuration(field string, value Duration) {
+	if value <= 0 {
+		v.add("%s must be positive, got %s", field, value)
+	}
+}
+
+func (v *validator) notNegativeDuration(field string, value Duration) {
+	if value < 0 {
+		v.add("%s must not be negative, got %s", field, value)
+	}
+}
+
+func (v *validator) absolute(field, path string) bool {
+	if !filepath.IsAbs(path) {
+		v.add("%s: %s is not an absolute path", field, path)
+		return false
+	}
+	return true
+}
+
+func (v *validator) httpURL(field, raw string) {
+	u, err := url.Parse(raw)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		v.add("%s: %v", field, err)
+		return
 	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add("%s: %s is not an http(s) URL", field, raw)
+	}
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// writableDir checks that files can be created in dir by this process
+func (v *validator) writableDir(field, dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		v.add("%s: directory %s: %v", field, dir, unwrapPathError(err))
+		return
+	}
+	if !info.IsDir() {
+		v.add("%s: %s is not a directory", field, dir)
+		return
 	}
+	if err := syscall.Access(dir, accessWriteOK); err != nil {
+		v.add("%s: directory %s is not writable: %v", field, dir, err)
+	}
+}
 
-	return &cfg, nil
+// accessWriteOK is W_OK for access(2)
+const accessWriteOK = 0x2
+
+// unwrapPathError drops the path already named in the message
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
 }
 
 func Default() *Config {
@@ -46,8 +512,30 @@ func Default() *Config {
 			"/usr/local/bin",
 			"/usr/local/sbin",
 		},
-		ScanInterval:  300, // 5 minutes
-		EnableWatcher: true,
-		LogFile:       "/var/log/integrity-monitor.log",
+		ScanInterval:    Duration(5 * time.Minute),
+		ShutdownTimeout: Duration(30 * time.Second),
+		PIDFile:         "/run/integrity-monitor/integrity-monitor.pid",
+		EnableWatcher:   true,
+		LogFile:         "/var/log/integrity-monitor.log",
+		AlertChain: AlertChainConfig{
+			ExportInterval: Duration(time.Hour),
+		},
+		Outbox: OutboxConfig{
+			PollInterval: Duration(30 * time.Second),
+			RetryBackoff: Duration(30 * time.Second),
+			MaxBackoff:   Duration(time.Hour),
+		},
+		Control: ControlConfig{
+			Socket: "/run/integrity-monitor/control.sock",
+		},
+		Health: HealthConfig{
+			StallTimeout: Duration(5 * time.Minute),
+		},
+		SelfCheck: SelfCheckConfig{
+			Interval: Duration(10 * time.Minute),
+		},
+		Packages: PackagesConfig{
+			MaxDefer: Duration(time.Hour),
+		},
 	}
 }
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment override, e.g.
+// INTEGRITY_MONITOR_SCAN_INTERVAL or INTEGRITY_MONITOR_DATABASE_PATH
+const EnvPrefix = "INTEGRITY_MONITOR_"
+
+// SourceDefault marks settings that no file or variable changed
+const SourceDefault = "default"
+
+// Sources maps the dotted path of each setting, e.g. "outbox.max_backoff"
+// or "notifiers.templates.alert", to the "file:line" or "env NAME" that
+// set it last. Lists count as one setting; maps are merged key by key.
+type Sources map[string]string
+
+// Lookup returns where the setting at path came from
+func (s Sources) Lookup(path string) string {
+	for p := path; p != ""; {
+		if source, ok := s[p]; ok {
+			return source
+		}
+		i := strings.LastIndex(p, ".")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return SourceDefault
+}
+
+// DropInDir is the conf.d directory next to configPath
+func DropInDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "conf.d")
+}
+
+// Files lists the files Load reads, in order: configPath, if it exists,
+// then the *.yaml files of its conf.d directory in lexical order
+func Files(configPath string) ([]string, error) {
+	var files []string
+	if _, err := os.Stat(configPath); err == nil {
+		files = append(files, configPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dropIns, err := filepath.Glob(filepath.Join(DropInDir(configPath), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dropIns)
+	return append(files, dropIns...), nil
+}
+
+// Load reads configPath and then its drop-ins over Default(), so omitted
+// settings keep their default values, and applies INTEGRITY_MONITOR_*
+// environment overrides last. A missing configPath counts as empty.
+// Unknown keys and variables are rejected, as they are usually typos; the
+// *ValidationError listing them comes with the configuration decoded from
+// the rest of the input, so that it can be validated too.
+func Load(configPath string) (*Config, error) {
+	cfg, _, err := LoadWithSources(configPath)
+	return cfg, err
+}
+
+// LoadWithSources is Load that also tells where each setting came from
+func LoadWithSources(configPath string) (*Config, Sources, error) {
+	files, err := Files(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	sources := make(Sources)
+	var problems []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+			var typeErr *yaml.TypeError
+			if !errors.As(err, &typeErr) {
+				return nil, nil, fmt.Errorf("failed to parse config file %s: %w", file, err)
+			}
+			for _, problem := range typeErr.Errors {
+				problems = append(problems, file+": "+problem)
+			}
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err == nil && len(doc.Content) > 0 {
+			recordSources(sources, "", doc.Content[0], file)
+		}
+	}
+
+	problems = append(problems, applyEnv(cfg, sources, os.Environ())...)
+
+	if len(problems) > 0 {
+		return cfg, sources, fmt.Errorf("failed to parse configuration: %w", &ValidationError{Problems: problems})
+	}
+	return cfg, sources, nil
+}
+
+// recordSources notes file as the source of every setting in node
+func recordSources(sources Sources, prefix string, node *yaml.Node, file string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+		if value.Kind == yaml.MappingNode && len(value.Content) > 0 {
+			recordSources(sources, path, value, file)
+			continue
+		}
+		clearSources(sources, path)
+		sources[path] = fmt.Sprintf("%s:%d", file, key.Line)
+	}
+}
+
+// clearSources forgets the sources of the settings below path, which was
+// replaced as a whole
+func clearSources(sources Sources, path string) {
+	for p := range sources {
+		if strings.HasPrefix(p, path+".") {
+			delete(sources, p)
+		}
+	}
+}
+
+// envSetting is a setting that an environment variable can override
+type envSetting struct {
+	path  string
+	index []int
+}
+
+// envSettings lists every setting of Config by variable name. Nested
+// sections are not settings themselves; their fields are.
+func envSettings() map[string]envSetting {
+	settings := make(map[string]envSetting)
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			path := name
+			if prefix != "" {
+				path = prefix + "." + name
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, path, fieldIndex)
+				continue
+			}
+			env := EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+			settings[env] = envSetting{path: path, index: fieldIndex}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	return settings
+}
+
+// EnvOverrides lists the INTEGRITY_MONITOR_* variables of the process
+// environment as sorted NAME=value pairs
+func EnvOverrides() []string {
+	var overrides []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, EnvPrefix) {
+			overrides = append(overrides, kv)
+		}
+	}
+	sort.Strings(overrides)
+	return overrides
+}
+
+// applyEnv sets the settings named by INTEGRITY_MONITOR_* variables in
+// environ. Strings are taken literally, lists of strings may be written
+// comma-separated, everything else is parsed as YAML, e.g. "5m", "true"
+// or "[{url: https://example.com/hook}]".
+func applyEnv(cfg *Config, sources Sources, environ []string) []string {
+	settings := envSettings()
+	var names []string
+	values := make(map[string]string)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, EnvPrefix) {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	sort.Strings(names)
+
+	var problems []string
+	root := reflect.ValueOf(cfg).Elem()
+	for _, name := range names {
+		setting, ok := settings[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: no such setting", name))
+			continue
+		}
+		field := root.FieldByIndex(setting.index)
+		value, err := parseEnv(field.Type(), values[name])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		field.Set(value)
+		clearSources(sources, setting.path)
+		sources[setting.path] = "env " + name
+	}
+	return problems
+}
+
+func parseEnv(t reflect.Type, raw string) (reflect.Value, error) {
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(raw).Convert(t), nil
+	}
+	if t == reflect.TypeOf([]string(nil)) && !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return reflect.ValueOf(list), nil
+	}
+
+	// Decode into a fresh value so that lists and maps are replaced
+	value := reflect.New(t)
+	decoder := yaml.NewDecoder(strings.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(value.Interface()); err != nil && err != io.EOF {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			var problems []string
+			for _, problem := range typeErr.Errors {
+				problems = append(problems, strings.TrimPrefix(problem, "line 1: "))
+			}
+			return reflect.Value{}, errors.New(strings.Join(problems, "; "))
+		}
+		return reflect.Value{}, err
+	}
+	return value.Elem(), nil
+}
+
+// Annotated renders cfg as YAML with the source of every setting in a
+// comment. Passwords, secrets and webhook headers are masked.
+func (c *Config) Annotated(sources Sources) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return nil, err
+	}
+	annotate(&doc, "", sources)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+// maskedKeys hold credentials that are not shown by Annotated
+var maskedKeys = map[string]bool{"password": true, "secret": true, "headers": true}
+
+func annotate(node *yaml.Node, prefix string, sources Sources) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+		if maskedKeys[key.Value] {
+			mask(value)
+		}
+		if value.Kind == yaml.SequenceNode {
+			// Webhooks and other lists of sections carry credentials too
+			for _, item := range value.Content {
+				annotate(item, "", nil)
+			}
+		}
+		if sources == nil {
+			continue
+		}
+
+		switch {
+		case value.Kind == yaml.MappingNode && len(value.Content) > 0:
+			annotate(value, path, sources)
+		case value.Kind == yaml.ScalarNode || len(value.Content) > 0:
+			key.LineComment = sources.Lookup(path)
+		default:
+			// Empty lists and maps are written inline after the key
+			value.LineComment = sources.Lookup(path)
+		}
+	}
+}
+
+// mask replaces every non-empty scalar below node
+func mask(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if node.Value != "" {
+			node.Value = "********"
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+		return
+	}
+	for i, child := range node.Content {
+		// Keep map keys such as header names readable
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		mask(child)
+	}
+}
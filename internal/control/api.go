@@ -0,0 +1,95 @@
+package control
+
+import (
+	"time"
+
+	"integrity-monitor/internal/health"
+	"integrity-monitor/pkg/models"
+)
+
+// Status describes the running daemon
+type Status struct {
+	PID            int              `json:"pid"`
+	Version        string           `json:"version"`
+	StartedAt      time.Time        `json:"started_at"`
+	Root           string           `json:"root"`
+	MonitoredPaths []string         `json:"monitored_paths"`
+	Schedules      []ScheduleStatus `json:"schedules"`
+	Watcher        bool             `json:"watcher"`
+	Paused         bool             `json:"paused"`
+	Scanning       bool             `json:"scanning"`
+	LastScan       *ScanResult      `json:"last_scan,omitempty"`
+	Health         health.Report    `json:"health"`
+}
+
+// ScheduleStatus is one entry of the daemon's scan plan
+type ScheduleStatus struct {
+	Name   string    `json:"name"`
+	Spec   string    `json:"spec"` // cron expression or "every <duration>"
+	Mode   string    `json:"mode"` // deep or quick
+	Jitter string    `json:"jitter,omitempty"`
+	Next   time.Time `json:"next"` // zero if the schedule never fires again
+}
+
+// ScanResult summarizes one full scan
+type ScanResult struct {
+	Mode       string    `json:"mode"` // deep or quick
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Files      int       `json:"files"`
+	Alerts     int       `json:"alerts"`
+	Errors     int       `json:"errors"`
+	Error      string    `json:"error,omitempty"` // the scan could not run
+}
+
+// StartMaintenanceRequest opens a maintenance window
+type StartMaintenanceRequest struct {
+	Paths    []string `json:"paths"`
+	Duration string   `json:"duration"` // Go duration, e.g. "30m"
+	Reason   string   `json:"reason"`
+	// StartedBy is not taken from clients: the server sets it from the
+	// kernel-reported credentials of the caller
+	StartedBy string `json:"-"`
+}
+
+// MaintenanceDetail is a window with the changes queued in it
+type MaintenanceDetail struct {
+	Window  *models.MaintenanceWindow   `json:"window"`
+	Changes []*models.MaintenanceChange `json:"changes"`
+}
+
+// EndMaintenanceRequest closes a window, accepting its changes if Approve
+type EndMaintenanceRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// EndMaintenanceResult reports what ending a window did
+type EndMaintenanceResult struct {
+	Window     *models.MaintenanceWindow `json:"window"`
+	Queued     int                       `json:"queued"`
+	Approved   int                       `json:"approved"`
+	Skipped    []string                  `json:"skipped,omitempty"` // reasons changes were not approved
+	Generation int64                     `json:"generation,omitempty"`
+}
+
+// Daemon is what the control socket exposes of the running monitor
+type Daemon interface {
+	Status() Status
+	// Scan runs a full scan now; with wait it returns once the scan is done
+	Scan(wait bool) (*ScanResult, error)
+	Pause() error
+	Resume() error
+	// Reload re-reads the configuration file
+	Reload() error
+	Alerts(limit int) ([]*models.Alert, error)
+	MaintenanceAPI
+}
+
+// MaintenanceAPI manages maintenance windows. Both the daemon and Client
+// implement it, so commands work the same with or without a daemon.
+type MaintenanceAPI interface {
+	StartMaintenance(req StartMaintenanceRequest) (*models.MaintenanceWindow, error)
+	MaintenanceWindows(openOnly bool) ([]*models.MaintenanceWindow, error)
+	Maintenance(id int64) (*MaintenanceDetail, error)
+	EndMaintenance(id int64, req EndMaintenanceRequest) (*EndMaintenanceResult, error)
+}
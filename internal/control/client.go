@@ -0,0 +1,143 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// ErrNotRunning means no daemon listens on the control socket
+var ErrNotRunning = errors.New("daemon is not running")
+
+// Client calls the control API of a running daemon
+type Client struct {
+	http *http.Client
+}
+
+// Dial connects to the daemon's control socket. It returns ErrNotRunning
+// when the socket is missing or stale, so callers can fall back to working
+// on the database directly.
+func Dial(path string) (*Client, error) {
+	if path == "" {
+		return nil, ErrNotRunning
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrNotRunning
+		}
+		return nil, err
+	}
+	conn.Close()
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}, nil
+}
+
+func (c *Client) call(method, endpoint string, query url.Values, body, result any) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	u := "http://integrity-monitor/v1/" + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("daemon returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	return &status, c.call(http.MethodGet, "status", nil, nil, &status)
+}
+
+// Scan starts a full scan; with wait it returns the result once done
+func (c *Client) Scan(wait bool) (*ScanResult, error) {
+	var result *ScanResult
+	query := url.Values{"wait": {strconv.FormatBool(wait)}}
+	return result, c.call(http.MethodPost, "scan", query, nil, &result)
+}
+
+func (c *Client) Pause() (*Status, error) {
+	var status Status
+	return &status, c.call(http.MethodPost, "pause", nil, nil, &status)
+}
+
+func (c *Client) Resume() (*Status, error) {
+	var status Status
+	return &status, c.call(http.MethodPost, "resume", nil, nil, &status)
+}
+
+func (c *Client) Reload() (*Status, error) {
+	var status Status
+	return &status, c.call(http.MethodPost, "reload", nil, nil, &status)
+}
+
+func (c *Client) Alerts(limit int) ([]*models.Alert, error) {
+	var alerts []*models.Alert
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	return alerts, c.call(http.MethodGet, "alerts", query, nil, &alerts)
+}
+
+func (c *Client) StartMaintenance(req StartMaintenanceRequest) (*models.MaintenanceWindow, error) {
+	var w models.MaintenanceWindow
+	return &w, c.call(http.MethodPost, "maintenance/start", nil, req, &w)
+}
+
+func (c *Client) MaintenanceWindows(openOnly bool) ([]*models.MaintenanceWindow, error) {
+	var windows []*models.MaintenanceWindow
+	query := url.Values{"all": {strconv.FormatBool(!openOnly)}}
+	return windows, c.call(http.MethodGet, "maintenance", query, nil, &windows)
+}
+
+func (c *Client) Maintenance(id int64) (*MaintenanceDetail, error) {
+	var detail MaintenanceDetail
+	return &detail, c.call(http.MethodGet, fmt.Sprintf("maintenance/%d", id), nil, nil, &detail)
+}
+
+func (c *Client) EndMaintenance(id int64, req EndMaintenanceRequest) (*EndMaintenanceResult, error) {
+	var result EndMaintenanceResult
+	return &result, c.call(http.MethodPost, fmt.Sprintf("maintenance/%d/end", id), nil, req, &result)
+}
@@ -0,0 +1,366 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ServerOptions controls who may use the control socket
+type ServerOptions struct {
+	// Group members may query the daemon; changing its state always
+	// requires root or the daemon's own user
+	Group string
+}
+
+// Server serves the control API over a Unix socket. Clients are identified
+// by the kernel-reported credentials of the connecting process
+// (SO_PEERCRED), not by anything they send.
+type Server struct {
+	path     string
+	daemon   Daemon
+	gid      int // -1 without a group
+	listener net.Listener
+	http     *http.Server
+}
+
+type credKey struct{}
+
+// Peer is the process at the other end of a control connection
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (p Peer) String() string {
+	return fmt.Sprintf("uid=%d pid=%d", p.UID, p.PID)
+}
+
+// Listen creates the socket at path, replacing a stale one left by a
+// previous run
+func Listen(path string, daemon Daemon, opts ServerOptions) (*Server, error) {
+	s := &Server{path: path, daemon: daemon, gid: -1}
+
+	if opts.Group != "" {
+		g, err := user.LookupGroup(opts.Group)
+		if err != nil {
+			return nil, fmt.Errorf("control group: %w", err)
+		}
+		if s.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("control group %s: invalid gid %s", opts.Group, g.Gid)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another daemon", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Bind in a private directory and move the socket into place once its
+	// ownership and mode are set, so it is never reachable before; the
+	// umask is process-wide and cannot be narrowed for this alone
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bound := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.Listen("unix", bound)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	mode := os.FileMode(0600)
+	if s.gid >= 0 {
+		if err := os.Chown(bound, -1, s.gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		mode = 0660
+	}
+	if err := os.Chmod(bound, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(bound, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s.listener = listener
+	s.http = &http.Server{
+		Handler: s.routes(),
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			peer, err := peerCredentials(conn)
+			if err != nil {
+				log.Printf("Control: failed to read peer credentials: %v", err)
+				return ctx
+			}
+			return context.WithValue(ctx, credKey{}, peer)
+		},
+	}
+	return s, nil
+}
+
+// Serve handles requests until Close
+func (s *Server) Serve() error {
+	err := s.http.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops serving and removes the socket
+func (s *Server) Close() error {
+	err := s.http.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// Shutdown stops accepting requests, waits until those in progress are
+// answered or ctx is done, and removes the socket
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	os.Remove(s.path)
+	return err
+}
+
+func peerCredentials(conn net.Conn) (Peer, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("not a unix connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return Peer{}, err
+	}
+	return Peer{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
+
+// authorize lets root and the daemon's user do anything and members of
+// the control group read
+func (s *Server) authorize(peer Peer, write bool) bool {
+	if peer.UID == 0 || int(peer.UID) == os.Getuid() {
+		return true
+	}
+	if write || s.gid < 0 {
+		return false
+	}
+	if int(peer.GID) == s.gid {
+		return true
+	}
+
+	u, err := user.LookupId(strconv.FormatUint(uint64(peer.UID), 10))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, gid := range groups {
+		if gid == strconv.Itoa(s.gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// route is one API endpoint; write endpoints change the daemon's state
+type route struct {
+	method string
+	write  bool
+	handle func(r *http.Request, peer Peer, arg string) (any, error)
+}
+
+func (s *Server) routes() http.Handler {
+	routes := map[string]route{
+		"status":            {http.MethodGet, false, s.handleStatus},
+		"scan":              {http.MethodPost, true, s.handleScan},
+		"pause":             {http.MethodPost, true, s.handlePause},
+		"resume":            {http.MethodPost, true, s.handleResume},
+		"reload":            {http.MethodPost, true, s.handleReload},
+		"alerts":            {http.MethodGet, false, s.handleAlerts},
+		"maintenance":       {http.MethodGet, false, s.handleListMaintenance},
+		"maintenance/start": {http.MethodPost, true, s.handleStartMaintenance},
+		"maintenance/*":     {http.MethodGet, false, s.handleShowMaintenance},
+		"maintenance/*/end": {http.MethodPost, true, s.handleEndMaintenance},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, ok := r.Context().Value(credKey{}).(Peer)
+		if !ok {
+			writeError(w, http.StatusForbidden, "peer credentials unavailable")
+			return
+		}
+
+		name, arg := matchRoute(strings.TrimPrefix(r.URL.Path, "/v1/"))
+		rt, found := routes[name]
+		if !found || !strings.HasPrefix(r.URL.Path, "/v1/") {
+			writeError(w, http.StatusNotFound, "no such endpoint")
+			return
+		}
+		if r.Method != rt.method {
+			writeError(w, http.StatusMethodNotAllowed, "use "+rt.method)
+			return
+		}
+		if !s.authorize(peer, rt.write) {
+			log.Printf("Control: denied %s %s to %s", r.Method, r.URL.Path, peer)
+			writeError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		if rt.write {
+			log.Printf("Control: %s %s by %s", r.Method, r.URL.Path, peer)
+		}
+
+		result, err := rt.handle(r, peer, arg)
+		if err != nil {
+			var bad badRequest
+			if errors.As(err, &bad) {
+				writeError(w, http.StatusBadRequest, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// matchRoute replaces a numeric path segment with "*" and returns it
+func matchRoute(path string) (name, arg string) {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if _, err := strconv.ParseInt(part, 10, 64); err == nil {
+			parts[i], arg = "*", part
+		}
+	}
+	return strings.Join(parts, "/"), arg
+}
+
+type badRequest struct{ error }
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func decodeBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v); err != nil {
+		return badRequest{fmt.Errorf("invalid request: %w", err)}
+	}
+	return nil
+}
+
+func (s *Server) handleStatus(r *http.Request, peer Peer, arg string) (any, error) {
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) handleScan(r *http.Request, peer Peer, arg string) (any, error) {
+	return s.daemon.Scan(r.URL.Query().Get("wait") == "true")
+}
+
+func (s *Server) handlePause(r *http.Request, peer Peer, arg string) (any, error) {
+	if err := s.daemon.Pause(); err != nil {
+		return nil, err
+	}
+	log.Printf("WARNING: monitoring paused via control socket by %s", peer)
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) handleResume(r *http.Request, peer Peer, arg string) (any, error) {
+	if err := s.daemon.Resume(); err != nil {
+		return nil, err
+	}
+	log.Printf("Monitoring resumed via control socket by %s", peer)
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) handleReload(r *http.Request, peer Peer, arg string) (any, error) {
+	if err := s.daemon.Reload(); err != nil {
+		return nil, err
+	}
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) handleAlerts(r *http.Request, peer Peer, arg string) (any, error) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, badRequest{fmt.Errorf("invalid limit %q", v)}
+		}
+		limit = n
+	}
+	return s.daemon.Alerts(limit)
+}
+
+func (s *Server) handleListMaintenance(r *http.Request, peer Peer, arg string) (any, error) {
+	return s.daemon.MaintenanceWindows(r.URL.Query().Get("all") != "true")
+}
+
+func (s *Server) handleStartMaintenance(r *http.Request, peer Peer, arg string) (any, error) {
+	var req StartMaintenanceRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	req.StartedBy = peerName(peer)
+	return s.daemon.StartMaintenance(req)
+}
+
+// peerName names the user of peer; unlike a name the client sends, it
+// cannot be forged
+func peerName(peer Peer) string {
+	if u, err := user.LookupId(strconv.FormatUint(uint64(peer.UID), 10)); err == nil {
+		return fmt.Sprintf("%s (uid %d)", u.Username, peer.UID)
+	}
+	return fmt.Sprintf("uid %d", peer.UID)
+}
+
+func (s *Server) handleShowMaintenance(r *http.Request, peer Peer, arg string) (any, error) {
+	id, _ := strconv.ParseInt(arg, 10, 64)
+	return s.daemon.Maintenance(id)
+}
+
+func (s *Server) handleEndMaintenance(r *http.Request, peer Peer, arg string) (any, error) {
+	id, _ := strconv.ParseInt(arg, 10, 64)
+	var req EndMaintenanceRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	return s.daemon.EndMaintenance(id, req)
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+func (s *SQLiteStorage) StartMaintenance(w *models.MaintenanceWindow) error {
+	query := `INSERT INTO maintenance_windows (kind, paths, reason, started_by, started_at, ends_at, status)
+	          VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	if w.Kind == "" {
+		w.Kind = models.MaintenanceManual
+	}
+	w.Status = models.MaintenanceOpen
+	result, err := s.db.Exec(query, w.Kind, strings.Join(w.Paths, "\n"), w.Reason, w.StartedBy,
+		w.StartedAt, w.EndsAt, w.Status)
+	if err != nil {
+		return err
+	}
+	w.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetMaintenanceWindows returns windows newest first; openOnly skips the
+// ones already ended
+func (s *SQLiteStorage) GetMaintenanceWindows(openOnly bool, limit int) ([]*models.MaintenanceWindow, error) {
+	query := `SELECT id, kind, paths, reason, started_by, started_at, ends_at, closed_at, status
+	          FROM maintenance_windows`
+	if openOnly {
+		query += ` WHERE status = '` + models.MaintenanceOpen + `'`
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []*models.MaintenanceWindow
+	for rows.Next() {
+		w, err := scanMaintenanceWindow(rows)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+func (s *SQLiteStorage) GetMaintenanceWindow(id int64) (*models.MaintenanceWindow, error) {
+	row := s.db.QueryRow(`SELECT id, kind, paths, reason, started_by, started_at, ends_at, closed_at, status
+	                      FROM maintenance_windows WHERE id = ?`, id)
+	w, err := scanMaintenanceWindow(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("maintenance window %d not found", id)
+	}
+	return w, err
+}
+
+// RecordMaintenanceChange queues a change in a window, keeping the
+// checksum seen first as the old value
+func (s *SQLiteStorage) RecordMaintenanceChange(change *models.MaintenanceChange) error {
+	query := `
+	INSERT INTO maintenance_changes (window_id, path, old_checksum, new_checksum, detected_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(window_id, path) DO UPDATE SET
+		new_checksum = excluded.new_checksum,
+		detected_at = excluded.detected_at
+	`
+	_, err := s.db.Exec(query, change.WindowID, change.Path, change.OldChecksum, change.NewChecksum, change.DetectedAt)
+	return err
+}
+
+func (s *SQLiteStorage) GetMaintenanceChanges(windowID int64) ([]*models.MaintenanceChange, error) {
+	rows, err := s.db.Query(`SELECT id, window_id, path, old_checksum, new_checksum, detected_at
+	                         FROM maintenance_changes WHERE window_id = ? ORDER BY path`, windowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*models.MaintenanceChange
+	for rows.Next() {
+		var c models.MaintenanceChange
+		if err := rows.Scan(&c.ID, &c.WindowID, &c.Path, &c.OldChecksum, &c.NewChecksum, &c.DetectedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// CloseMaintenanceWindow ends an open window with the given final status
+func (s *SQLiteStorage) CloseMaintenanceWindow(id int64, status string) error {
+	result, err := s.db.Exec(`UPDATE maintenance_windows SET status = ?, closed_at = ?
+	                          WHERE id = ? AND status = ?`, status, time.Now(), id, models.MaintenanceOpen)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("maintenance window %d is not open", id)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMaintenanceWindow(row rowScanner) (*models.MaintenanceWindow, error) {
+	var w models.MaintenanceWindow
+	var paths string
+	var closedAt sql.NullTime
+	if err := row.Scan(&w.ID, &w.Kind, &paths, &w.Reason, &w.StartedBy, &w.StartedAt, &w.EndsAt, &closedAt, &w.Status); err != nil {
+		return nil, err
+	}
+	if paths != "" {
+		w.Paths = strings.Split(paths, "\n")
+	}
+	if closedAt.Valid {
+		w.ClosedAt = &closedAt.Time
+	}
+	return &w, nil
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one schema change, applied in Version order
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migrations embedded in the binary, named
+// migrations/NNNN_description.sql
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, desc, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: desc, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version the binary expects
+func LatestSchemaVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func (s *SQLiteStorage) ensureVersionTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration, 0 for a new or
+// pre-migration database
+func (s *SQLiteStorage) SchemaVersion() (int, error) {
+	var tables int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+	                      WHERE type = 'table' AND name = 'schema_version'`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// checkSchema fails unless every migration has been applied
+func (s *SQLiteStorage) checkSchema() error {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("database schema version %d is behind %d; run `integrity-monitor db migrate` first",
+		current, pending[len(pending)-1].Version)
+}
+
+// PendingMigrations returns the migrations not yet applied
+func (s *SQLiteStorage) PendingMigrations() ([]Migration, error) {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+		return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d)",
+			current, migrations[len(migrations)-1].Version)
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies pending migrations, each in its own transaction. A
+// database that already contains tables is backed up before the first change.
+func (s *SQLiteStorage) Migrate() ([]Migration, error) {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	if err := s.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+
+	hasData, err := s.hasUserTables()
+	if err != nil {
+		return nil, err
+	}
+	if hasData {
+		backup, err := s.Backup()
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		log.Printf("Database backed up to %s before migrating", backup)
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		if err := s.applyMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied schema migration %04d_%s", m.Version, m.Name)
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+func (s *SQLiteStorage) applyMigration(m Migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) hasUserTables() (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+	                      WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'`).Scan(&count)
+	return count > 0, err
+}
+
+// Backup writes a consistent copy of the database next to it and returns
+// the copy's path
+func (s *SQLiteStorage) Backup() (string, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", s.path, version, time.Now().Format("20060102-150405"))
+	if _, err := s.db.Exec(`VACUUM INTO ?`, backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS utilities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	path TEXT NOT NULL UNIQUE,
+	checksum TEXT NOT NULL,
+	last_modified DATETIME NOT NULL,
+	size INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_utilities_path ON utilities(path);
+CREATE INDEX IF NOT EXISTS idx_utilities_checksum ON utilities(checksum);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	utility_path TEXT NOT NULL,
+	old_checksum TEXT NOT NULL,
+	new_checksum TEXT NOT NULL,
+	detected_at DATETIME NOT NULL,
+	severity TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_detected_at ON alerts(detected_at);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	alert_id INTEGER NOT NULL REFERENCES alerts(id),
+	channel TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	delivered_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_status_next ON outbox(status, next_attempt_at);
@@ -0,0 +1,31 @@
+CREATE TABLE snapshots (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	description TEXT NOT NULL,
+	file_count INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE snapshot_entries (
+	snapshot_id INTEGER NOT NULL REFERENCES snapshots(id),
+	path TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	last_modified DATETIME NOT NULL,
+	size INTEGER NOT NULL,
+	PRIMARY KEY (snapshot_id, path)
+);
+
+CREATE TABLE utility_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	path TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	last_modified DATETIME NOT NULL,
+	size INTEGER NOT NULL,
+	change TEXT NOT NULL,
+	recorded_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_utility_history_path ON utility_history(path, recorded_at);
+
+-- Seed history with the baseline that existed before history was kept
+INSERT INTO utility_history (path, checksum, last_modified, size, change, recorded_at)
+SELECT path, checksum, last_modified, size, 'added', updated_at FROM utilities;
@@ -0,0 +1,8 @@
+CREATE TABLE baseline_signatures (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	merkle_root TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	key_id TEXT NOT NULL,
+	file_count INTEGER NOT NULL,
+	signed_at DATETIME NOT NULL
+);
@@ -0,0 +1,14 @@
+-- Alerts written before this migration keep empty hashes and are reported
+-- as unchained by verify-log
+ALTER TABLE alerts ADD COLUMN prev_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE alerts ADD COLUMN hash TEXT NOT NULL DEFAULT '';
+
+CREATE TRIGGER alerts_append_only_update BEFORE UPDATE ON alerts
+BEGIN
+	SELECT RAISE(ABORT, 'alerts are append-only');
+END;
+
+CREATE TRIGGER alerts_append_only_delete BEFORE DELETE ON alerts
+BEGIN
+	SELECT RAISE(ABORT, 'alerts are append-only');
+END;
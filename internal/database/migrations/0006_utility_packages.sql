@@ -0,0 +1,3 @@
+-- Owning package of each file, filled in when package verification is enabled
+ALTER TABLE utilities ADD COLUMN package TEXT NOT NULL DEFAULT '';
+ALTER TABLE utilities ADD COLUMN package_version TEXT NOT NULL DEFAULT '';
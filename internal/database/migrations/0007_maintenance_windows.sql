@@ -0,0 +1,20 @@
+CREATE TABLE maintenance_windows (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	paths TEXT NOT NULL,              -- newline-separated
+	reason TEXT NOT NULL,
+	started_by TEXT NOT NULL,
+	started_at DATETIME NOT NULL,
+	ends_at DATETIME NOT NULL,
+	closed_at DATETIME,
+	status TEXT NOT NULL
+);
+
+CREATE TABLE maintenance_changes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	window_id INTEGER NOT NULL REFERENCES maintenance_windows(id),
+	path TEXT NOT NULL,
+	old_checksum TEXT NOT NULL,
+	new_checksum TEXT NOT NULL,
+	detected_at DATETIME NOT NULL,
+	UNIQUE(window_id, path)
+);
@@ -0,0 +1,3 @@
+-- Windows opened by the monitor for package updates awaiting approval into a
+-- signed baseline, as opposed to the ones opened by an operator
+ALTER TABLE maintenance_windows ADD COLUMN kind TEXT NOT NULL DEFAULT 'manual';
@@ -0,0 +1,6 @@
+-- What an alert reports and, for anything but a checksum mismatch, its
+-- description. Records chained before this migration keep hash version 1,
+-- which does not cover the new columns.
+ALTER TABLE alerts ADD COLUMN kind TEXT NOT NULL DEFAULT 'mismatch';
+ALTER TABLE alerts ADD COLUMN message TEXT NOT NULL DEFAULT '';
+ALTER TABLE alerts ADD COLUMN hash_version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// SetOutboxChannels sets the channels every new alert is queued for
+func (s *SQLiteStorage) SetOutboxChannels(channels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels = append([]string(nil), channels...)
+}
+
+func (s *SQLiteStorage) enqueueDeliveries(tx *sql.Tx, alertID int64) error {
+	s.mu.RLock()
+	channels := s.channels
+	s.mu.RUnlock()
+
+	query := `INSERT INTO outbox (alert_id, channel, status, next_attempt_at, created_at)
+	          VALUES (?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	for _, channel := range channels {
+		if _, err := tx.Exec(query, alertID, channel, models.DeliveryPending, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const deliveryColumns = `o.id, o.channel, o.status, o.attempts, o.last_error, o.next_attempt_at,
+	o.created_at, o.delivered_at, a.id, a.kind, a.utility_path, a.old_checksum, a.new_checksum,
+	a.message, a.detected_at, a.severity, a.hash_version, a.prev_hash, a.hash`
+
+// GetDueDeliveries returns pending deliveries whose next attempt is due,
+// starting after the entry afterID
+func (s *SQLiteStorage) GetDueDeliveries(now time.Time, afterID int64, limit int) ([]*models.Delivery, error) {
+	query := `SELECT ` + deliveryColumns + `
+	          FROM outbox o JOIN alerts a ON a.id = o.alert_id
+	          WHERE o.status = ? AND o.next_attempt_at <= ? AND o.id > ?
+	          ORDER BY o.id LIMIT ?`
+
+	return s.queryDeliveries(query, models.DeliveryPending, now, afterID, limit)
+}
+
+// GetDeliveries lists outbox entries, newest first; an empty status matches all
+func (s *SQLiteStorage) GetDeliveries(status string, limit int) ([]*models.Delivery, error) {
+	query := `SELECT ` + deliveryColumns + `
+	          FROM outbox o JOIN alerts a ON a.id = o.alert_id
+	          WHERE ? = '' OR o.status = ?
+	          ORDER BY o.id DESC LIMIT ?`
+
+	return s.queryDeliveries(query, status, status, limit)
+}
+
+func (s *SQLiteStorage) queryDeliveries(query string, args ...interface{}) ([]*models.Delivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.Delivery
+	for rows.Next() {
+		var d models.Delivery
+		var alert models.Alert
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID, &d.Channel, &d.Status, &d.Attempts, &d.LastError, &d.NextAttemptAt,
+			&d.CreatedAt, &deliveredAt, &alert.ID, &alert.Kind, &alert.UtilityPath, &alert.OldChecksum,
+			&alert.NewChecksum, &alert.Message, &alert.DetectedAt, &alert.Severity, &alert.HashVersion,
+			&alert.PrevHash, &alert.Hash,
+		); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		d.Alert = &alert
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *SQLiteStorage) MarkDelivered(id int64) error {
+	query := `UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = '', delivered_at = ?
+	          WHERE id = ?`
+
+	_, err := s.db.Exec(query, models.DeliveryDelivered, time.Now(), id)
+	return err
+}
+
+// MarkDeliveryFailed records a failed attempt; with giveUp the entry is no
+// longer retried until requeued manually
+func (s *SQLiteStorage) MarkDeliveryFailed(id int64, reason string, nextAttempt time.Time, giveUp bool) error {
+	status := models.DeliveryPending
+	if giveUp {
+		status = models.DeliveryFailed
+	}
+
+	query := `UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+	          WHERE id = ?`
+
+	_, err := s.db.Exec(query, status, reason, nextAttempt, id)
+	return err
+}
+
+// RequeueFailedDeliveries puts abandoned entries back into the pending state
+func (s *SQLiteStorage) RequeueFailedDeliveries() (int64, error) {
+	query := `UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE status = ?`
+
+	result, err := s.db.Exec(query, models.DeliveryPending, time.Now(), models.DeliveryFailed)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
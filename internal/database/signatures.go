@@ -0,0 +1,38 @@
+package database
+
+import (
+	"database/sql"
+
+	"integrity-monitor/pkg/models"
+)
+
+func (s *SQLiteStorage) SaveBaselineSignature(sig *models.BaselineSignature) error {
+	query := `INSERT INTO baseline_signatures (merkle_root, signature, key_id, file_count, signed_at)
+	          VALUES (?, ?, ?, ?, ?)`
+
+	result, err := s.db.Exec(query, sig.MerkleRoot, sig.Signature, sig.KeyID, sig.FileCount, sig.SignedAt)
+	if err != nil {
+		return err
+	}
+	sig.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetBaselineSignature returns the most recent signature, or nil if the
+// baseline was never signed
+func (s *SQLiteStorage) GetBaselineSignature() (*models.BaselineSignature, error) {
+	query := `SELECT id, merkle_root, signature, key_id, file_count, signed_at
+	          FROM baseline_signatures ORDER BY id DESC LIMIT 1`
+
+	var sig models.BaselineSignature
+	err := s.db.QueryRow(query).Scan(&sig.ID, &sig.MerkleRoot, &sig.Signature, &sig.KeyID,
+		&sig.FileCount, &sig.SignedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sig, nil
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// CreateSnapshot freezes the current baseline as a new generation
+func (s *SQLiteStorage) CreateSnapshot(description string) (*models.Snapshot, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snapshot, err := createSnapshot(tx, description)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, tx.Commit()
+}
+
+func createSnapshot(tx *sql.Tx, description string) (*models.Snapshot, error) {
+	now := time.Now()
+	result, err := tx.Exec(`INSERT INTO snapshots (description, file_count, created_at) VALUES (?, 0, ?)`,
+		description, now)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = tx.Exec(`
+	INSERT INTO snapshot_entries (snapshot_id, path, checksum, last_modified, size)
+	SELECT ?, path, checksum, last_modified, size FROM utilities`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE snapshots SET file_count = ? WHERE id = ?`, count, id); err != nil {
+		return nil, err
+	}
+
+	return &models.Snapshot{ID: id, Description: description, FileCount: int(count), CreatedAt: now}, nil
+}
+
+func (s *SQLiteStorage) GetSnapshots() ([]*models.Snapshot, error) {
+	rows, err := s.db.Query(`SELECT id, description, file_count, created_at FROM snapshots ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*models.Snapshot
+	for rows.Next() {
+		var snap models.Snapshot
+		if err := rows.Scan(&snap.ID, &snap.Description, &snap.FileCount, &snap.CreatedAt); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, &snap)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// GetSnapshotEntries returns the baseline frozen in a snapshot
+func (s *SQLiteStorage) GetSnapshotEntries(id int64) ([]*models.Utility, error) {
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM snapshots WHERE id = ?`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("snapshot %d does not exist", id)
+	}
+
+	rows, err := s.db.Query(`SELECT path, checksum, last_modified, size
+	                         FROM snapshot_entries WHERE snapshot_id = ? ORDER BY path`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var utilities []*models.Utility
+	for rows.Next() {
+		var util models.Utility
+		if err := rows.Scan(&util.Path, &util.Checksum, &util.LastModified, &util.Size); err != nil {
+			return nil, err
+		}
+		utilities = append(utilities, &util)
+	}
+
+	return utilities, rows.Err()
+}
+
+// RollbackToSnapshot makes the given generation the active baseline again
+// and records the result as a new snapshot
+func (s *SQLiteStorage) RollbackToSnapshot(id int64) (*models.Snapshot, error) {
+	entries, err := s.GetSnapshotEntries(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.applyBaseline(entries, true, models.ChangeRollback, fmt.Sprintf("rollback to generation %d", id))
+}
+
+// ImportBaseline loads externally produced entries into the active baseline.
+// With replace, files missing from entries are removed; otherwise entries
+// are merged over the existing baseline. The result is recorded as a snapshot.
+func (s *SQLiteStorage) ImportBaseline(entries []*models.Utility, replace bool, description string) (*models.Snapshot, error) {
+	return s.applyBaseline(entries, replace, "", description)
+}
+
+func (s *SQLiteStorage) applyBaseline(entries []*models.Utility, replace bool, change, description string) (*models.Snapshot, error) {
+	current, err := s.GetAllUtilities()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	wanted := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		wanted[entry.Path] = true
+		if err := saveUtility(tx, entry, change); err != nil {
+			return nil, err
+		}
+	}
+
+	if replace {
+		now := time.Now()
+		for _, util := range current {
+			if wanted[util.Path] {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM utilities WHERE path = ?`, util.Path); err != nil {
+				return nil, err
+			}
+			if err := recordHistory(tx, util, models.ChangeRemoved, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	snapshot, err := createSnapshot(tx, description)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, tx.Commit()
+}
+
+// GetUtilityHistory returns every recorded baseline value of a file, oldest first
+func (s *SQLiteStorage) GetUtilityHistory(path string) ([]*models.HistoryEntry, error) {
+	rows, err := s.db.Query(`SELECT id, path, checksum, last_modified, size, change, recorded_at
+	                         FROM utility_history WHERE path = ? ORDER BY recorded_at, id`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*models.HistoryEntry
+	for rows.Next() {
+		var entry models.HistoryEntry
+		if err := rows.Scan(&entry.ID, &entry.Path, &entry.Checksum, &entry.LastModified,
+			&entry.Size, &entry.Change, &entry.RecordedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, &entry)
+	}
+
+	return history, rows.Err()
+}
@@ -3,89 +3,164 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"integrity-monitor/internal/auditlog"
+	"integrity-monitor/internal/metrics"
 	"integrity-monitor/pkg/models"
 )
 
 type SQLiteStorage struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
+
+	mu       sync.RWMutex
+	channels []string
+
+	alertMu sync.Mutex
 }
 
+// NewSQLiteStorage opens a database whose schema is up to date; pending
+// migrations are an error until they are applied with `db migrate`
 func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	storage, err := OpenSQLiteStorage(dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if err := storage.checkSchema(); err != nil {
+		storage.Close()
+		return nil, err
 	}
 
-	storage := &SQLiteStorage{db: db}
-	if err := storage.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	return storage, nil
+}
+
+// NewMigratedSQLiteStorage opens the database and brings its schema up to
+// date, backing the file up first if it already holds data. The caller
+// must keep other processes away from the database, e.g. by holding the
+// instance lock.
+func NewMigratedSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+	storage, err := OpenSQLiteStorage(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := storage.Migrate(); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
 	}
 
 	return storage, nil
 }
 
-func (s *SQLiteStorage) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS utilities (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		path TEXT NOT NULL UNIQUE,
-		checksum TEXT NOT NULL,
-		last_modified DATETIME NOT NULL,
-		size INTEGER NOT NULL,
-		created_at DATETIME NOT NULL,
-		updated_at DATETIME NOT NULL
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_utilities_path ON utilities(path);
-	CREATE INDEX IF NOT EXISTS idx_utilities_checksum ON utilities(checksum);
-
-	CREATE TABLE IF NOT EXISTS alerts (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		utility_path TEXT NOT NULL,
-		old_checksum TEXT NOT NULL,
-		new_checksum TEXT NOT NULL,
-		detected_at DATETIME NOT NULL,
-		severity TEXT NOT NULL
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_alerts_detected_at ON alerts(detected_at);
-	`
+// OpenSQLiteStorage opens the database without touching its schema
+func OpenSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+	return openSQLite(dbPath, dbPath)
+}
 
-	_, err := s.db.Exec(schema)
-	return err
+// OpenSQLiteStorageReadOnly opens an existing database for reading only
+func OpenSQLiteStorageReadOnly(dbPath string) (*SQLiteStorage, error) {
+	dsn := (&url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro"}).String()
+	return openSQLite(dbPath, dsn)
 }
 
+func openSQLite(dbPath, dsn string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &SQLiteStorage{db: db, path: dbPath}, nil
+}
+
+// SaveUtility upserts the baseline entry of a file and records it in the
+// history when its checksum changes
 func (s *SQLiteStorage) SaveUtility(util *models.Utility) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveUtility(tx, util, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// saveUtility upserts util; change overrides the history kind derived from
+// the previous value
+func saveUtility(tx *sql.Tx, util *models.Utility, change string) error {
+	var previous string
+	err := tx.QueryRow(`SELECT checksum FROM utilities WHERE path = ?`, util.Path).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		if change == "" {
+			change = models.ChangeAdded
+		}
+	case err != nil:
+		return err
+	case previous == util.Checksum:
+		change = ""
+	case change == "":
+		change = models.ChangeUpdated
+	}
+
+	// An entry without an owner (rollbacks, imports of old exports) keeps
+	// the known package only while the content is unchanged; the package
+	// says nothing about a different checksum
 	query := `
-	INSERT INTO utilities (path, checksum, last_modified, size, created_at, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?)
+	INSERT INTO utilities (path, checksum, last_modified, size, package, package_version, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(path) DO UPDATE SET
 		checksum = excluded.checksum,
 		last_modified = excluded.last_modified,
 		size = excluded.size,
+		package = CASE WHEN excluded.package != '' OR excluded.checksum != utilities.checksum
+			THEN excluded.package ELSE utilities.package END,
+		package_version = CASE WHEN excluded.package != '' OR excluded.checksum != utilities.checksum
+			THEN excluded.package_version ELSE utilities.package_version END,
 		updated_at = excluded.updated_at
 	`
 
 	now := time.Now()
-	_, err := s.db.Exec(query, util.Path, util.Checksum, util.LastModified, util.Size, now, now)
+	if _, err := tx.Exec(query, util.Path, util.Checksum, util.LastModified, util.Size,
+		util.Package, util.PackageVersion, now, now); err != nil {
+		return err
+	}
+
+	if change == "" {
+		return nil
+	}
+	return recordHistory(tx, util, change, now)
+}
+
+func recordHistory(tx *sql.Tx, util *models.Utility, change string, now time.Time) error {
+	query := `INSERT INTO utility_history (path, checksum, last_modified, size, change, recorded_at)
+	          VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := tx.Exec(query, util.Path, util.Checksum, util.LastModified, util.Size, change, now)
 	return err
 }
 
 func (s *SQLiteStorage) GetUtility(path string) (*models.Utility, error) {
-	query := `SELECT id, path, checksum, last_modified, size, created_at, updated_at
+	query := `SELECT id, path, checksum, last_modified, size, package, package_version, created_at, updated_at
 	          FROM utilities WHERE path = ?`
 
 	var util models.Utility
 	err := s.db.QueryRow(query, path).Scan(
 		&util.ID, &util.Path, &util.Checksum, &util.LastModified,
-		&util.Size, &util.CreatedAt, &util.UpdatedAt,
+		&util.Size, &util.Package, &util.PackageVersion, &util.CreatedAt, &util.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -99,7 +174,7 @@ func (s *SQLiteStorage) GetUtility(path string) (*models.Utility, error) {
 }
 
 func (s *SQLiteStorage) GetAllUtilities() ([]*models.Utility, error) {
-	query := `SELECT id, path, checksum, last_modified, size, created_at, updated_at
+	query := `SELECT id, path, checksum, last_modified, size, package, package_version, created_at, updated_at
 	          FROM utilities ORDER BY path`
 
 	rows, err := s.db.Query(query)
@@ -113,7 +188,7 @@ func (s *SQLiteStorage) GetAllUtilities() ([]*models.Utility, error) {
 		var util models.Utility
 		if err := rows.Scan(
 			&util.ID, &util.Path, &util.Checksum, &util.LastModified,
-			&util.Size, &util.CreatedAt, &util.UpdatedAt,
+			&util.Size, &util.Package, &util.PackageVersion, &util.CreatedAt, &util.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -123,20 +198,96 @@ func (s *SQLiteStorage) GetAllUtilities() ([]*models.Utility, error) {
 	return utilities, rows.Err()
 }
 
-func (s *SQLiteStorage) SaveAlert(alert *models.Alert) error {
-	query := `INSERT INTO alerts (utility_path, old_checksum, new_checksum, detected_at, severity)
-	          VALUES (?, ?, ?, ?, ?)`
+// SaveAlert appends the alert to the hash chain and, in the same
+// transaction, queues it in the outbox for every configured channel. On
+// failure alert.ID is left 0, marking it as not queued.
+func (s *SQLiteStorage) SaveAlert(alert *models.Alert) (err error) {
+	if alert.Kind == "" {
+		alert.Kind = models.AlertMismatch
+	}
 
-	_, err := s.db.Exec(query, alert.UtilityPath, alert.OldChecksum, alert.NewChecksum,
-		alert.DetectedAt, alert.Severity)
-	return err
+	// Serialize writers so two alerts never link to the same predecessor
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+	defer func() {
+		if err != nil {
+			alert.ID = 0
+		}
+	}()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRow(`SELECT hash FROM alerts ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	alert.HashVersion = auditlog.HashVersion
+	alert.PrevHash = prevHash
+	alert.Hash = auditlog.AlertHash(prevHash, alert)
+
+	query := `INSERT INTO alerts (kind, utility_path, old_checksum, new_checksum, message, detected_at,
+	                              severity, hash_version, prev_hash, hash)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, alert.Kind, alert.UtilityPath, alert.OldChecksum, alert.NewChecksum,
+		alert.Message, alert.DetectedAt, alert.Severity, alert.HashVersion, alert.PrevHash, alert.Hash)
+	if err != nil {
+		return err
+	}
+
+	alert.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := s.enqueueDeliveries(tx, alert.ID); err != nil {
+		return fmt.Errorf("failed to enqueue alert: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	metrics.Alerts.Inc(alert.Severity)
+	return nil
 }
 
 func (s *SQLiteStorage) GetRecentAlerts(limit int) ([]*models.Alert, error) {
-	query := `SELECT utility_path, old_checksum, new_checksum, detected_at, severity
+	query := `SELECT ` + alertColumns + `
 	          FROM alerts ORDER BY detected_at DESC LIMIT ?`
 
-	rows, err := s.db.Query(query, limit)
+	return s.queryAlerts(query, limit)
+}
+
+// GetAlertChain returns alerts with an ID greater than afterID in chain order
+func (s *SQLiteStorage) GetAlertChain(afterID int64, limit int) ([]*models.Alert, error) {
+	query := `SELECT ` + alertColumns + `
+	          FROM alerts WHERE id > ? ORDER BY id LIMIT ?`
+
+	return s.queryAlerts(query, afterID, limit)
+}
+
+// GetAlertSequence returns the highest alert ID ever allocated, which
+// reveals alerts deleted from the end of the chain
+func (s *SQLiteStorage) GetAlertSequence() (int64, error) {
+	var seq int64
+	err := s.db.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = 'alerts'`).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+const alertColumns = `id, kind, utility_path, old_checksum, new_checksum, message, detected_at, severity,
+	hash_version, prev_hash, hash`
+
+func (s *SQLiteStorage) queryAlerts(query string, args ...interface{}) ([]*models.Alert, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -145,8 +296,9 @@ func (s *SQLiteStorage) GetRecentAlerts(limit int) ([]*models.Alert, error) {
 	var alerts []*models.Alert
 	for rows.Next() {
 		var alert models.Alert
-		if err := rows.Scan(&alert.UtilityPath, &alert.OldChecksum, &alert.NewChecksum,
-			&alert.DetectedAt, &alert.Severity); err != nil {
+		if err := rows.Scan(&alert.ID, &alert.Kind, &alert.UtilityPath, &alert.OldChecksum, &alert.NewChecksum,
+			&alert.Message, &alert.DetectedAt, &alert.Severity, &alert.HashVersion, &alert.PrevHash,
+			&alert.Hash); err != nil {
 			return nil, err
 		}
 		alerts = append(alerts, &alert)
@@ -1,6 +1,10 @@
 package database
 
-import "integrity-monitor/pkg/models"
+import (
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
 
 // Storage defines the interface for database operations
 type Storage interface {
@@ -9,5 +13,35 @@ type Storage interface {
 	GetAllUtilities() ([]*models.Utility, error)
 	SaveAlert(alert *models.Alert) error
 	GetRecentAlerts(limit int) ([]*models.Alert, error)
+	GetAlertChain(afterID int64, limit int) ([]*models.Alert, error)
+	GetAlertSequence() (int64, error)
+
+	// Immutable baseline generations and per-file history
+	CreateSnapshot(description string) (*models.Snapshot, error)
+	GetSnapshots() ([]*models.Snapshot, error)
+	GetSnapshotEntries(id int64) ([]*models.Utility, error)
+	RollbackToSnapshot(id int64) (*models.Snapshot, error)
+	ImportBaseline(entries []*models.Utility, replace bool, description string) (*models.Snapshot, error)
+	GetUtilityHistory(path string) ([]*models.HistoryEntry, error)
+
+	// Signatures over the active baseline
+	SaveBaselineSignature(sig *models.BaselineSignature) error
+	GetBaselineSignature() (*models.BaselineSignature, error)
+
+	// Maintenance windows queue changes for approval instead of alerting
+	StartMaintenance(w *models.MaintenanceWindow) error
+	GetMaintenanceWindows(openOnly bool, limit int) ([]*models.MaintenanceWindow, error)
+	GetMaintenanceWindow(id int64) (*models.MaintenanceWindow, error)
+	RecordMaintenanceChange(change *models.MaintenanceChange) error
+	GetMaintenanceChanges(windowID int64) ([]*models.MaintenanceChange, error)
+	CloseMaintenanceWindow(id int64, status string) error
+
+	// Outbox of alerts awaiting delivery to notification channels
+	SetOutboxChannels(channels []string)
+	GetDueDeliveries(now time.Time, afterID int64, limit int) ([]*models.Delivery, error)
+	GetDeliveries(status string, limit int) ([]*models.Delivery, error)
+	MarkDelivered(id int64) error
+	MarkDeliveryFailed(id int64, reason string, nextAttempt time.Time, giveUp bool) error
+	RequeueFailedDeliveries() (int64, error)
 	Close() error
 }
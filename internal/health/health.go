@@ -0,0 +1,173 @@
+// Package health tracks whether the daemon's loops are making progress.
+// Each loop beats while it works and while it waits; a loop whose last beat
+// is older than the stall timeout is considered hung.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Monitor collects the heartbeats of the daemon's components
+type Monitor struct {
+	stallTimeout time.Duration
+
+	mu         sync.Mutex
+	components map[string]*Component
+	order      []string
+}
+
+func NewMonitor(stallTimeout time.Duration) *Monitor {
+	if stallTimeout <= 0 {
+		stallTimeout = 5 * time.Minute
+	}
+	return &Monitor{stallTimeout: stallTimeout, components: make(map[string]*Component)}
+}
+
+// Component is one loop reporting to a Monitor
+type Component struct {
+	monitor  *Monitor
+	name     string
+	lastBeat time.Time
+	ready    bool
+	message  string
+}
+
+// Register adds a component; it is not ready until SetReady
+func (m *Monitor) Register(name string) *Component {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &Component{monitor: m, name: name, lastBeat: time.Now()}
+	if _, ok := m.components[name]; !ok {
+		m.order = append(m.order, name)
+		sort.Strings(m.order)
+	}
+	m.components[name] = c
+	return c
+}
+
+// Unregister removes a component that was shut down on purpose
+func (m *Monitor) Unregister(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.components, name)
+	for i, n := range m.order {
+		if n == name {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Beat records progress
+func (c *Component) Beat() {
+	c.monitor.mu.Lock()
+	c.lastBeat = time.Now()
+	c.monitor.mu.Unlock()
+}
+
+type beatKey struct{}
+
+// WithBeat returns a context under which long-running work, such as hashing
+// a large file, reports its progress to beat
+func WithBeat(ctx context.Context, beat func()) context.Context {
+	return context.WithValue(ctx, beatKey{}, beat)
+}
+
+// Beat records progress of the work running under ctx, if it was started
+// with WithBeat
+func Beat(ctx context.Context) {
+	if beat, ok := ctx.Value(beatKey{}).(func()); ok {
+		beat()
+	}
+}
+
+// SetReady marks whether the component is doing its job; message explains
+// why not, or what it is doing
+func (c *Component) SetReady(ready bool, message string) {
+	c.monitor.mu.Lock()
+	c.ready = ready
+	c.message = message
+	c.lastBeat = time.Now()
+	c.monitor.mu.Unlock()
+}
+
+// ComponentReport is the state of one component
+type ComponentReport struct {
+	Healthy  bool      `json:"healthy"`
+	Ready    bool      `json:"ready"`
+	LastBeat time.Time `json:"last_beat"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// Report is the state of all components. Healthy means no loop is hung;
+// Ready means every component is also doing its job.
+type Report struct {
+	Healthy    bool                       `json:"healthy"`
+	Ready      bool                       `json:"ready"`
+	Components map[string]ComponentReport `json:"components"`
+}
+
+func (m *Monitor) Check() Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	report := Report{Healthy: true, Ready: true, Components: make(map[string]ComponentReport, len(m.components))}
+	for _, name := range m.order {
+		c := m.components[name]
+		r := ComponentReport{
+			Healthy:  now.Sub(c.lastBeat) <= m.stallTimeout,
+			Ready:    c.ready,
+			LastBeat: c.lastBeat,
+			Message:  c.message,
+		}
+		if !r.Healthy {
+			r.Ready = false
+			r.Message = "no progress since " + c.lastBeat.Format("15:04:05")
+		}
+		report.Healthy = report.Healthy && r.Healthy
+		report.Ready = report.Ready && r.Ready
+		report.Components[name] = r
+	}
+	return report
+}
+
+// Unhealthy lists the components that stopped making progress
+func (r Report) Unhealthy() []string {
+	var names []string
+	for name, c := range r.Components {
+		if !c.Healthy {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LivenessHandler serves /healthz: 200 unless a loop is hung
+func (m *Monitor) LivenessHandler() http.Handler {
+	return m.handler(func(r Report) bool { return r.Healthy })
+}
+
+// ReadinessHandler serves /readyz: 200 once every component is working
+func (m *Monitor) ReadinessHandler() http.Handler {
+	return m.handler(func(r Report) bool { return r.Ready })
+}
+
+func (m *Monitor) handler(ok func(Report) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := m.Check()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(report) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
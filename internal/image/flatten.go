@@ -0,0 +1,285 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Whiteout markers of the OCI layer format
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// node is one path of the flattened filesystem
+type node struct {
+	typ   byte
+	mode  os.FileMode
+	layer int
+	// src is the tar entry holding the file content; for hard links it is
+	// the entry of the link target at the time the link was created
+	src  entryRef
+	link string
+}
+
+type entryRef struct {
+	layer, index int
+}
+
+type tree map[string]*node
+
+// Flatten writes the merged filesystem of all layers to dest, which must
+// be an empty directory. Only directories, regular files and symlinks are
+// created; ownership, devices and setuid bits are not reproduced. Layers
+// are merged in memory first and symlinks are created last, so links in
+// the image can never redirect writes outside dest.
+func (img *Image) Flatten(dest string) error {
+	t := tree{"/": {typ: tar.TypeDir, mode: 0755, layer: -1}}
+
+	for i := range img.Layers {
+		err := img.readLayer(i, func(index int, hdr *tar.Header, _ io.Reader) error {
+			t.apply(i, index, hdr)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return img.write(t, dest)
+}
+
+func (img *Image) readLayer(i int, fn func(index int, hdr *tar.Header, r io.Reader) error) error {
+	name := img.Layers[i]
+	f, err := img.files.open(name)
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", name, err)
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", name, err)
+	}
+
+	tr := tar.NewReader(r)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", name, err)
+		}
+		if err := fn(index, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress detects gzip-compressed layers; uncompressed tar is returned as is
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, errors.New("zstd-compressed layers are not supported")
+	}
+	return br, nil
+}
+
+// apply merges one tar entry of layer into the tree
+func (t tree) apply(layer, index int, hdr *tar.Header) {
+	name := path.Clean("/" + hdr.Name)
+	dir, base := path.Split(name)
+	dir = path.Clean(dir)
+
+	switch {
+	case base == whiteoutOpaque:
+		// Hide everything lower layers put in dir
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		for p, n := range t {
+			if strings.HasPrefix(p, prefix) && p != dir && n.layer < layer {
+				delete(t, p)
+			}
+		}
+		return
+	case strings.HasPrefix(base, whiteoutPrefix):
+		t.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		return
+	}
+
+	n := &node{typ: hdr.Typeflag, mode: os.FileMode(hdr.Mode).Perm(), layer: layer, src: entryRef{layer, index}}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if name == "/" {
+			return
+		}
+	case tar.TypeReg:
+	case tar.TypeSymlink:
+		n.link = hdr.Linkname
+	case tar.TypeLink:
+		target, ok := t[path.Clean("/"+hdr.Linkname)]
+		if !ok || target.typ != tar.TypeReg {
+			log.Printf("Warning: skipping hard link %s to missing file %s", name, hdr.Linkname)
+			return
+		}
+		// A hard link shares the inode, and so the mode, of its target
+		n.typ = tar.TypeReg
+		n.mode = target.mode
+		n.src = target.src
+	default:
+		// Devices and FIFOs carry no content worth verifying
+		return
+	}
+
+	t.makeParents(name, layer)
+	if old, ok := t[name]; ok && old.typ == tar.TypeDir {
+		if n.typ == tar.TypeDir {
+			old.mode = n.mode
+			return
+		}
+		t.remove(name)
+	}
+	t[name] = n
+}
+
+// makeParents turns every ancestor of name into a directory
+func (t tree) makeParents(name string, layer int) {
+	var parents []string
+	for p := path.Dir(name); p != "/"; p = path.Dir(p) {
+		parents = append(parents, p)
+	}
+
+	for i := len(parents) - 1; i >= 0; i-- {
+		p := parents[i]
+		if n, ok := t[p]; ok && n.typ == tar.TypeDir {
+			continue
+		}
+		t.remove(p)
+		t[p] = &node{typ: tar.TypeDir, mode: 0755, layer: layer}
+	}
+}
+
+// remove deletes name and everything below it
+func (t tree) remove(name string) {
+	delete(t, name)
+	prefix := name + "/"
+	for p := range t {
+		if strings.HasPrefix(p, prefix) {
+			delete(t, p)
+		}
+	}
+}
+
+func (img *Image) write(t tree, dest string) error {
+	paths := make([]string, 0, len(t))
+	for p := range t {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// Parents sort before their children
+	contents := make(map[entryRef][]string)
+	var links []string
+	for _, p := range paths {
+		n := t[p]
+		switch n.typ {
+		case tar.TypeDir:
+			if p != "/" {
+				if err := os.Mkdir(hostPath(dest, p), 0755); err != nil {
+					return err
+				}
+			}
+		case tar.TypeReg:
+			contents[n.src] = append(contents[n.src], p)
+		case tar.TypeSymlink:
+			links = append(links, p)
+		}
+	}
+
+	for i := range img.Layers {
+		err := img.readLayer(i, func(index int, hdr *tar.Header, r io.Reader) error {
+			targets := contents[entryRef{i, index}]
+			if len(targets) == 0 {
+				return nil
+			}
+			return writeFile(dest, targets, t, hdr, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range links {
+		if err := os.Symlink(t[p].link, hostPath(dest, p)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile stores one tar entry at every path that has its content
+func writeFile(dest string, targets []string, t tree, hdr *tar.Header, r io.Reader) error {
+	first := hostPath(dest, targets[0])
+	if err := createFile(first, t[targets[0]].mode, r); err != nil {
+		return err
+	}
+	os.Chtimes(first, hdr.ModTime, hdr.ModTime)
+
+	for _, p := range targets[1:] {
+		src, err := os.Open(first)
+		if err != nil {
+			return err
+		}
+		err = createFile(hostPath(dest, p), t[p].mode, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+		os.Chtimes(hostPath(dest, p), hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
+
+func createFile(name string, mode os.FileMode, r io.Reader) error {
+	// O_EXCL never follows an existing symlink; owner read/write keeps
+	// the tree readable and removable without root
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// The umask may have cleared execute bits the scanner relies on
+	return os.Chmod(name, mode|0600)
+}
+
+func hostPath(dest, name string) string {
+	return filepath.Join(dest, filepath.FromSlash(name))
+}
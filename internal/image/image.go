@@ -0,0 +1,340 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Media types of manifests that list other manifests
+const (
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationRefName     = "org.opencontainers.image.ref.name"
+	annotationContainerd  = "io.containerd.image.name"
+	maxArchiveLinkHops    = 16
+	maxManifestIndexDepth = 4
+)
+
+// Image is a container image stored as a "docker save" tarball or an OCI
+// image layout directory. Layers are ordered from the base upwards.
+type Image struct {
+	Ref    string
+	Layers []string
+	files  fileSource
+}
+
+// fileSource opens files by their path inside the image archive
+type fileSource interface {
+	open(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// Open reads the image at path. ref selects an image when the archive holds
+// several; it matches a repository tag or an OCI ref.name annotation.
+func Open(path, ref string) (*Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files fileSource
+	if info.IsDir() {
+		files = dirSource(path)
+	} else {
+		files, err = openTarSource(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img, err := readManifest(files, ref)
+	if err != nil {
+		files.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	img.files = files
+	return img, nil
+}
+
+func (img *Image) Close() error {
+	return img.files.Close()
+}
+
+func readManifest(files fileSource, ref string) (*Image, error) {
+	// docker save writes manifest.json; since Docker 25 it also includes an
+	// OCI index.json, but manifest.json is simpler and always complete
+	if r, err := files.open("manifest.json"); err == nil {
+		defer r.Close()
+		return readDockerManifest(r, ref)
+	}
+
+	r, err := files.open("index.json")
+	if err != nil {
+		return nil, errors.New("neither manifest.json nor index.json found, not a docker save archive or OCI layout")
+	}
+	defer r.Close()
+	return readOCIIndex(files, r, ref)
+}
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func readDockerManifest(r io.Reader, ref string) (*Image, error) {
+	var manifests []dockerManifest
+	if err := json.NewDecoder(r).Decode(&manifests); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+
+	var candidates []dockerManifest
+	for _, m := range manifests {
+		if ref == "" || containsString(m.RepoTags, ref) {
+			candidates = append(candidates, m)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && ref != "":
+		return nil, fmt.Errorf("image %q not found in archive", ref)
+	case len(candidates) == 0:
+		return nil, errors.New("archive contains no images")
+	case len(candidates) > 1:
+		var tags []string
+		for _, m := range candidates {
+			tags = append(tags, m.RepoTags...)
+		}
+		return nil, fmt.Errorf("archive contains %d images, select one with -ref (%s)", len(candidates), strings.Join(tags, ", "))
+	}
+
+	m := candidates[0]
+	name := ref
+	if name == "" && len(m.RepoTags) > 0 {
+		name = m.RepoTags[0]
+	}
+	return &Image{Ref: name, Layers: m.Layers}, nil
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+func readOCIIndex(files fileSource, r io.Reader, ref string) (*Image, error) {
+	var index ociIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+
+	var candidates []ociDescriptor
+	for _, desc := range index.Manifests {
+		if ref == "" || desc.Annotations[annotationRefName] == ref || desc.Annotations[annotationContainerd] == ref {
+			candidates = append(candidates, desc)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && ref != "":
+		return nil, fmt.Errorf("image %q not found in layout", ref)
+	case len(candidates) == 0:
+		return nil, errors.New("layout contains no images")
+	case len(candidates) > 1:
+		var refs []string
+		for _, desc := range candidates {
+			refs = append(refs, desc.Annotations[annotationRefName])
+		}
+		return nil, fmt.Errorf("layout contains %d images, select one with -ref (%s)", len(candidates), strings.Join(refs, ", "))
+	}
+
+	desc := candidates[0]
+	name := ref
+	if name == "" {
+		name = desc.Annotations[annotationRefName]
+	}
+
+	layers, err := resolveManifest(files, desc, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Ref: name, Layers: layers}, nil
+}
+
+// resolveManifest returns the layer blobs of desc, choosing the manifest for
+// the running platform when desc is a multi-platform index
+func resolveManifest(files fileSource, desc ociDescriptor, depth int) ([]string, error) {
+	if depth > maxManifestIndexDepth {
+		return nil, errors.New("image indexes nested too deeply")
+	}
+
+	r, err := files.open(blobPath(desc.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", desc.Digest, err)
+	}
+	defer r.Close()
+
+	if desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerList {
+		var index ociIndex
+		if err := json.NewDecoder(r).Decode(&index); err != nil {
+			return nil, fmt.Errorf("failed to parse index %s: %w", desc.Digest, err)
+		}
+		platform, err := selectPlatform(index.Manifests)
+		if err != nil {
+			return nil, err
+		}
+		return resolveManifest(files, platform, depth+1)
+	}
+
+	var manifest ociManifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", desc.Digest, err)
+	}
+
+	layers := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		layers = append(layers, blobPath(layer.Digest))
+	}
+	return layers, nil
+}
+
+func selectPlatform(manifests []ociDescriptor) (ociDescriptor, error) {
+	var available []string
+	for _, desc := range manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		if desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+		available = append(available, desc.Platform.OS+"/"+desc.Platform.Architecture)
+	}
+
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("no manifest for %s/%s (available: %s)",
+		runtime.GOOS, runtime.GOARCH, strings.Join(available, ", "))
+}
+
+// blobPath maps "sha256:abc..." to "blobs/sha256/abc..."
+func blobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", algorithm, hex)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSource reads an OCI image layout directory
+type dirSource string
+
+func (d dirSource) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(path.Clean("/"+name))))
+}
+
+func (d dirSource) Close() error {
+	return nil
+}
+
+// tarSource reads members of a tarball in place, without extracting it
+type tarSource struct {
+	f       *os.File
+	members map[string]tarMember
+}
+
+type tarMember struct {
+	offset, size int64
+	link         string // target of a symlink or hard link member
+}
+
+// countingReader tracks the offset of member data in the tarball
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func openTarSource(file string) (*tarSource, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &tarSource{f: f, members: make(map[string]tarMember)}
+	counter := &countingReader{r: f}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			src.members[name] = tarMember{offset: counter.n, size: hdr.Size}
+		case tar.TypeSymlink:
+			// Older docker save archives link duplicate layers to each other
+			src.members[name] = tarMember{link: path.Join(path.Dir(name), hdr.Linkname)}
+		case tar.TypeLink:
+			src.members[name] = tarMember{link: path.Clean("/" + hdr.Linkname)}
+		}
+	}
+
+	return src, nil
+}
+
+func (t *tarSource) open(name string) (io.ReadCloser, error) {
+	name = path.Clean("/" + name)
+	for hops := 0; hops < maxArchiveLinkHops; hops++ {
+		member, ok := t.members[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+		}
+		if member.link == "" {
+			return io.NopCloser(io.NewSectionReader(t.f, member.offset, member.size)), nil
+		}
+		name = path.Clean(member.link)
+	}
+	return nil, fmt.Errorf("%s: too many links", name)
+}
+
+func (t *tarSource) Close() error {
+	return t.f.Close()
+}
@@ -0,0 +1,131 @@
+// Package instance keeps two processes from changing the same baseline
+// database at once: the daemon, -init and commands that replace the
+// baseline hold an exclusive flock(2) on a lock file next to the database.
+package instance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Lock is held until Release or until the process exits; the kernel drops
+// it even if the process is killed, so a stale lock file is harmless
+type Lock struct {
+	file *os.File
+}
+
+// Holder is the process that holds a lock
+type Holder struct {
+	PID     int
+	Command string
+}
+
+// LockedError means another process holds the lock
+type LockedError struct {
+	Path   string
+	Holder *Holder // nil if the holder could not be identified
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by pid %d (%s)", e.Path, e.Holder.PID, e.Holder.Command)
+}
+
+// LockPath returns the lock file guarding the database at dbPath
+func LockPath(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), filepath.Base(dbPath)+".lock")
+}
+
+// Acquire takes the lock guarding the database at dbPath without waiting.
+// command describes the holder to whoever finds the lock taken, e.g.
+// "monitor" or "init". It returns a *LockedError if the lock is held.
+func Acquire(dbPath, command string) (*Lock, error) {
+	path := LockPath(dbPath)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &LockedError{Path: path, Holder: readHolder(path)}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Record the holder for diagnostics; the lock itself is the flock
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%d\n%s\n", os.Getpid(), command)
+		file.Sync()
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release drops the lock. The file is left in place: removing it would let
+// a process that opened it before the removal lock a file nobody else sees.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.file.Truncate(0)
+	err := l.file.Close() // closing the last descriptor drops the flock
+	l.file = nil
+	return err
+}
+
+func readHolder(path string) *Holder {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	lines := bufio.NewScanner(file)
+	if !lines.Scan() {
+		return nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(lines.Text()))
+	if err != nil || pid <= 0 {
+		return nil
+	}
+	holder := &Holder{PID: pid, Command: "unknown"}
+	if lines.Scan() {
+		holder.Command = strings.TrimSpace(lines.Text())
+	}
+	return holder
+}
+
+// WritePIDFile records the current process ID at path for scripts and
+// init systems; it is informational, the lock is what keeps instances apart
+func WritePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return nil
+}
+
+// RemovePIDFile deletes the PID file at path if it still names this process
+func RemovePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	os.Remove(path)
+}
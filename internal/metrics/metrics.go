@@ -0,0 +1,48 @@
+// Package metrics exposes the monitor's own health in the Prometheus text
+// format, so that a monitor that stopped scanning or alerting is noticed.
+package metrics
+
+// File states counted by FilesByState after each full scan
+const (
+	StateOK       = "ok"
+	StateModified = "modified"
+	StateError    = "error"
+)
+
+var (
+	BuildInfo = NewGauge("integrity_monitor_build_info",
+		"Always 1; labeled with the running version.", "version")
+	StartTime = NewGauge("integrity_monitor_start_time_seconds",
+		"Unix time the daemon started.")
+	Paused = NewGauge("integrity_monitor_paused",
+		"1 while monitoring is paused through the control socket.")
+
+	ScanDuration = NewHistogram("integrity_monitor_scan_duration_seconds",
+		"Duration of full scans.",
+		1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600)
+	Scans = NewCounter("integrity_monitor_scans_total",
+		"Full scans by result (ok, failed).", "result")
+	LastSuccessfulScan = NewGauge("integrity_monitor_last_successful_scan_timestamp_seconds",
+		"Unix time the last full scan completed.")
+	FilesByState = NewGauge("integrity_monitor_files",
+		"Files found by the last full scan by state (ok, modified, error).", "state")
+
+	FilesHashed = NewCounter("integrity_monitor_files_hashed_total",
+		"Files hashed.")
+	BytesHashed = NewCounter("integrity_monitor_bytes_hashed_total",
+		"Bytes read while hashing files.")
+
+	Alerts = NewCounter("integrity_monitor_alerts_total",
+		"Alerts recorded by severity.", "severity")
+	Deliveries = NewCounter("integrity_monitor_notifier_deliveries_total",
+		"Alerts delivered by notification channel.", "channel")
+	DeliveryFailures = NewCounter("integrity_monitor_notifier_failures_total",
+		"Failed alert deliveries by notification channel.", "channel")
+
+	WatcherEvents = NewCounter("integrity_monitor_watcher_events_total",
+		"Filesystem events handled by the watcher by operation.", "op")
+	WatcherErrors = NewCounter("integrity_monitor_watcher_errors_total",
+		"Errors reported by the filesystem watcher, such as event queue overflows.")
+	WatcherQueueDepth = NewGauge("integrity_monitor_watcher_queue_depth",
+		"Filesystem events waiting to be checked.")
+)
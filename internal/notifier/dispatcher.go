@@ -0,0 +1,317 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"integrity-monitor/internal/database"
+	"integrity-monitor/internal/metrics"
+	"integrity-monitor/pkg/models"
+)
+
+// DispatcherOptions controls retries of outbox deliveries
+type DispatcherOptions struct {
+	PollInterval time.Duration
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int // 0 retries forever
+}
+
+// Dispatcher delivers alerts queued in the storage outbox to named channels.
+// Alerts are enqueued by Storage.SaveAlert, so SendAlert only wakes the
+// delivery loop, unless saving failed.
+type Dispatcher struct {
+	storage  database.Storage
+	mu       sync.RWMutex
+	channels map[string]Notifier
+	opts     DispatcherOptions
+	wake     chan struct{}
+	held     atomic.Int32
+}
+
+func NewDispatcher(storage database.Storage, channels map[string]Notifier, opts DispatcherOptions) *Dispatcher {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 30 * time.Second
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+
+	d := &Dispatcher{
+		storage: storage,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+	}
+	d.SetChannels(channels)
+	return d
+}
+
+// SetChannels replaces the notification channels, e.g. after the
+// configuration was reloaded; alerts saved from now on are queued for them
+func (d *Dispatcher) SetChannels(channels map[string]Notifier) {
+	names := make([]string, 0, len(channels))
+	for name := range channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d.mu.Lock()
+	d.channels = channels
+	d.storage.SetOutboxChannels(names)
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) SendAlert(ctx context.Context, alert *models.Alert) error {
+	if alert.ID == 0 {
+		return d.sendDirect(ctx, alert)
+	}
+	if d.held.Load() == 0 || d.immediate(alert) {
+		d.Wake()
+	}
+	return nil
+}
+
+// immediate reports whether some channel delivers alert while a scan holds
+// deliveries back
+func (d *Dispatcher) immediate(alert *models.Alert) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, n := range d.channels {
+		if immediateOn(n, alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// immediateOn reports whether n sends alert on its own rather than in a
+// digest, as configured for it
+func immediateOn(n Notifier, alert *models.Alert) bool {
+	b, ok := n.(BatchNotifier)
+	return ok && b.Batching() && b.Immediate(alert)
+}
+
+// sendDirect delivers an alert that never made it into the outbox straight
+// to every channel, once and without retries, rather than not at all
+func (d *Dispatcher) sendDirect(ctx context.Context, alert *models.Alert) error {
+	d.mu.RLock()
+	channels := d.channels
+	d.mu.RUnlock()
+
+	log.Printf("Alert for %s is not in the outbox, delivering it directly", alert.UtilityPath)
+	var errs []error
+	for name, n := range channels {
+		if err := n.SendAlert(ctx, alert); err != nil {
+			metrics.DeliveryFailures.Inc(name)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		metrics.Deliveries.Inc(name)
+	}
+	return errors.Join(errs...)
+}
+
+// BeginBatch holds deliveries back so that a whole scan is delivered in one
+// pass, letting batching channels send a single digest
+func (d *Dispatcher) BeginBatch() {
+	d.held.Add(1)
+}
+
+// EndBatch releases the held deliveries
+func (d *Dispatcher) EndBatch(ctx context.Context) error {
+	d.held.Add(-1)
+	d.Wake()
+	return nil
+}
+
+// Wake triggers a delivery pass without waiting for the poll interval
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due outbox entries whenever woken or polled, until ctx is
+// cancelled; while a batch is held only those that channels send
+// immediately are. Entries left undelivered stay in the outbox.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.flush(ctx, d.held.Load() > 0); err != nil && ctx.Err() == nil {
+			log.Printf("Outbox delivery error: %v", err)
+		}
+
+		select {
+		case <-d.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush attempts every due delivery once. It stops when ctx is cancelled;
+// a delivery interrupted that way is not counted as a failed attempt.
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	return d.flush(ctx, false)
+}
+
+// flush attempts due deliveries, only those a channel sends immediately if
+// immediateOnly. Every due entry is loaded before any is delivered, so a
+// batching channel gets the whole scan in one digest however many pages it
+// spans.
+func (d *Dispatcher) flush(ctx context.Context, immediateOnly bool) error {
+	const pageSize = 100
+
+	d.mu.RLock()
+	channels := d.channels
+	d.mu.RUnlock()
+
+	now := time.Now()
+	byChannel := make(map[string][]*models.Delivery)
+	var afterID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		deliveries, err := d.storage.GetDueDeliveries(now, afterID, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to load outbox: %w", err)
+		}
+
+		for _, delivery := range deliveries {
+			afterID = delivery.ID
+			if immediateOnly && !immediateOn(channels[delivery.Channel], delivery.Alert) {
+				continue
+			}
+			byChannel[delivery.Channel] = append(byChannel[delivery.Channel], delivery)
+		}
+
+		if len(deliveries) < pageSize {
+			break
+		}
+	}
+
+	for channel, batch := range byChannel {
+		n, ok := channels[channel]
+		if !ok {
+			// Channel no longer configured; keep entries for the operator
+			d.fail(batch, fmt.Errorf("channel %q is not configured", channel), true)
+			continue
+		}
+		d.deliver(ctx, n, batch)
+	}
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, n Notifier, batch []*models.Delivery) {
+	if b, ok := n.(BatchNotifier); ok && b.Batching() && len(batch) > 1 {
+		// Alerts the channel sends immediately go out ahead of the digest
+		var digest []*models.Delivery
+		for _, delivery := range batch {
+			if !b.Immediate(delivery.Alert) {
+				digest = append(digest, delivery)
+			} else if !d.deliverOne(ctx, n, delivery) {
+				return
+			}
+		}
+
+		// An alert SendAlert rejected is not part of the digest
+		var buffered []*models.Delivery
+		b.BeginBatch()
+		for _, delivery := range digest {
+			if err := b.SendAlert(ctx, delivery.Alert); err != nil {
+				if ctx.Err() == nil {
+					d.fail([]*models.Delivery{delivery}, err, false)
+				}
+				continue
+			}
+			buffered = append(buffered, delivery)
+		}
+		if err := b.EndBatch(ctx); err != nil {
+			if ctx.Err() == nil {
+				d.fail(buffered, err, false)
+			}
+		} else {
+			d.succeed(buffered)
+		}
+		return
+	}
+
+	for _, delivery := range batch {
+		if !d.deliverOne(ctx, n, delivery) {
+			return
+		}
+	}
+}
+
+// deliverOne sends a single alert outside any batch; it reports false once
+// ctx is cancelled
+func (d *Dispatcher) deliverOne(ctx context.Context, n Notifier, delivery *models.Delivery) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err := n.SendAlert(ctx, delivery.Alert); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		d.fail([]*models.Delivery{delivery}, err, false)
+	} else {
+		d.succeed([]*models.Delivery{delivery})
+	}
+	return true
+}
+
+func (d *Dispatcher) succeed(batch []*models.Delivery) {
+	for _, delivery := range batch {
+		metrics.Deliveries.Inc(delivery.Channel)
+		if err := d.storage.MarkDelivered(delivery.ID); err != nil {
+			log.Printf("Failed to mark outbox entry %d delivered: %v", delivery.ID, err)
+		}
+	}
+}
+
+func (d *Dispatcher) fail(batch []*models.Delivery, cause error, giveUp bool) {
+	for _, delivery := range batch {
+		metrics.DeliveryFailures.Inc(delivery.Channel)
+		attempts := delivery.Attempts + 1
+		abandon := giveUp || (d.opts.MaxAttempts > 0 && attempts >= d.opts.MaxAttempts)
+		next := time.Now().Add(d.backoff(attempts))
+
+		if abandon {
+			log.Printf("Giving up on alert %d for channel %s after %d attempts: %v",
+				delivery.Alert.ID, delivery.Channel, attempts, cause)
+		} else {
+			log.Printf("Delivery of alert %d to %s failed (attempt %d), retrying at %s: %v",
+				delivery.Alert.ID, delivery.Channel, attempts, next.Format("15:04:05"), cause)
+		}
+
+		if err := d.storage.MarkDeliveryFailed(delivery.ID, cause.Error(), next, abandon); err != nil {
+			log.Printf("Failed to update outbox entry %d: %v", delivery.ID, err)
+		}
+	}
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.opts.RetryBackoff
+	for i := 1; i < attempts && backoff < d.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.opts.MaxBackoff {
+		backoff = d.opts.MaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,281 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// EmailOptions configures delivery of alerts over SMTP
+type EmailOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// StartTLS upgrades the connection and refuses to send if the server
+	// does not offer STARTTLS
+	StartTLS bool
+	// Recipients maps a severity to its addresses; "default" is used for
+	// severities without their own list
+	Recipients map[string][]string
+	// Digest groups all alerts of one batch (periodic scan) into one message
+	Digest bool
+	// Immediate lists severities sent on their own as soon as they are
+	// raised instead of joining the digest; none by default
+	Immediate []string
+	Timeout   time.Duration
+	// Messages renders the subject, plain text and HTML bodies
+	Messages *Messages
+}
+
+type EmailNotifier struct {
+	opts     EmailOptions
+	hostname string
+
+	mu       sync.Mutex
+	batching bool
+	pending  []*models.Alert
+}
+
+func NewEmailNotifier(opts EmailOptions) (*EmailNotifier, error) {
+	if opts.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	if opts.From == "" {
+		return nil, fmt.Errorf("sender address is required")
+	}
+	if len(opts.Recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+	if opts.Port == 0 {
+		opts.Port = 587
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Messages == nil {
+		opts.Messages = DefaultMessages()
+	}
+
+	hostname, _ := os.Hostname()
+	return &EmailNotifier{opts: opts, hostname: hostname}, nil
+}
+
+func (n *EmailNotifier) SendAlert(ctx context.Context, alert *models.Alert) error {
+	n.mu.Lock()
+	if n.opts.Digest && n.batching && !n.Immediate(alert) {
+		n.pending = append(n.pending, alert)
+		n.mu.Unlock()
+		return nil
+	}
+	n.mu.Unlock()
+
+	return n.deliver(ctx, []*models.Alert{alert})
+}
+
+// Batching reports whether alerts are collected into digests
+func (n *EmailNotifier) Batching() bool {
+	return n.opts.Digest
+}
+
+// Immediate reports whether alert's severity is exempt from the digest
+func (n *EmailNotifier) Immediate(alert *models.Alert) bool {
+	for _, severity := range n.opts.Immediate {
+		if alert.Severity == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginBatch starts collecting alerts for a digest message
+func (n *EmailNotifier) BeginBatch() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.batching = true
+}
+
+// EndBatch sends the collected alerts, if any, as a single digest
+func (n *EmailNotifier) EndBatch(ctx context.Context) error {
+	n.mu.Lock()
+	alerts := n.pending
+	n.pending = nil
+	n.batching = false
+	n.mu.Unlock()
+
+	if len(alerts) == 0 {
+		return nil
+	}
+	return n.deliver(ctx, alerts)
+}
+
+// deliver sends one message per recipient containing the alerts routed to it
+func (n *EmailNotifier) deliver(ctx context.Context, alerts []*models.Alert) error {
+	byRecipient := make(map[string][]*models.Alert)
+	for _, alert := range alerts {
+		for _, rcpt := range n.recipientsFor(alert.Severity) {
+			byRecipient[rcpt] = append(byRecipient[rcpt], alert)
+		}
+	}
+
+	if len(byRecipient) == 0 {
+		return fmt.Errorf("no recipients configured for severity %q", alerts[0].Severity)
+	}
+
+	addrs := make([]string, 0, len(byRecipient))
+	for rcpt := range byRecipient {
+		addrs = append(addrs, rcpt)
+	}
+	sort.Strings(addrs)
+
+	var failed []string
+	for _, rcpt := range addrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := n.buildMessage(rcpt, byRecipient[rcpt])
+		if err != nil {
+			return err
+		}
+		if err := n.send(ctx, rcpt, msg); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", rcpt, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send email: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (n *EmailNotifier) recipientsFor(severity string) []string {
+	if rcpts, ok := n.opts.Recipients[strings.ToLower(severity)]; ok {
+		return rcpts
+	}
+	return n.opts.Recipients["default"]
+}
+
+func (n *EmailNotifier) send(ctx context.Context, rcpt string, msg []byte) error {
+	addr := net.JoinHostPort(n.opts.Host, fmt.Sprint(n.opts.Port))
+	dialer := &net.Dialer{Timeout: n.opts.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.opts.Timeout))
+	// Abort the SMTP conversation on cancellation
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.opts.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(n.hostname); err != nil {
+		return err
+	}
+
+	if n.opts.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: n.opts.Host}); err != nil {
+			return err
+		}
+	}
+
+	if n.opts.Username != "" {
+		auth := smtp.PlainAuth("", n.opts.Username, n.opts.Password, n.opts.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(n.opts.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (n *EmailNotifier) buildMessage(rcpt string, alerts []*models.Alert) ([]byte, error) {
+	subject, err := n.opts.Messages.Render(MsgEmailSubject, alerts...)
+	if err != nil {
+		return nil, err
+	}
+	text, err := n.opts.Messages.Render(MsgEmailText, alerts...)
+	if err != nil {
+		return nil, err
+	}
+	html, err := n.opts.Messages.Render(MsgEmailHTML, alerts...)
+	if err != nil {
+		return nil, err
+	}
+	subject = strings.TrimSpace(subject)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.opts.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", rcpt)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", []byte(text)},
+		{"text/html; charset=utf-8", []byte(html)},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	mw.Close()
+
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
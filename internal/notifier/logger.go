@@ -1,29 +1,30 @@
 package notifier
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"time"
 
 	"integrity-monitor/pkg/models"
 )
 
 type FileLogger struct {
-	logFile string
+	logFile  string
+	messages *Messages
 }
 
-func NewFileLogger(logFile string) *FileLogger {
-	return &FileLogger{logFile: logFile}
+func NewFileLogger(logFile string, messages *Messages) *FileLogger {
+	if messages == nil {
+		messages = DefaultMessages()
+	}
+	return &FileLogger{logFile: logFile, messages: messages}
 }
 
-func (l *FileLogger) SendAlert(alert *models.Alert) error {
-	message := fmt.Sprintf("[%s] ALERT: %s - Utility %s modified (old: %s, new: %s)\n",
-		time.Now().Format("2006-01-02 15:04:05"),
-		alert.Severity,
-		alert.UtilityPath,
-		alert.OldChecksum,
-		alert.NewChecksum,
-	)
+func (l *FileLogger) SendAlert(ctx context.Context, alert *models.Alert) error {
+	message, err := l.messages.Render(MsgLogAlert, alert)
+	if err != nil {
+		return err
+	}
 
 	f, err := os.OpenFile(l.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -0,0 +1,301 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// Message names used by the notifiers; each can be overridden from config
+const (
+	MsgTTYAlert     = "tty.alert"
+	MsgLogAlert     = "log.alert"
+	MsgWebhookText  = "webhook.text"
+	MsgEmailSubject = "email.subject"
+	MsgEmailText    = "email.text"
+	MsgEmailHTML    = "email.html"
+
+	// kind.<alert kind> describes one alert in a line, see describe
+	MsgKindMismatch           = "kind." + models.AlertMismatch
+	MsgKindBaselineUntrusted  = "kind." + models.AlertBaselineUntrusted
+	MsgKindPackageUpdate      = "kind." + models.AlertPackageUpdate
+	MsgKindLockOverdue        = "kind." + models.AlertLockOverdue
+	MsgKindMaintenanceExpired = "kind." + models.AlertMaintenanceExpired
+	MsgKindSelfCheck          = "kind." + models.AlertSelfCheck
+)
+
+// DefaultLocale is used when no locale is configured
+const DefaultLocale = "en"
+
+// MessageData is passed to every message template
+type MessageData struct {
+	Alert    *models.Alert // first (or only) alert
+	Alerts   []*models.Alert
+	Hostname string
+	Now      time.Time
+}
+
+// Messages renders alert texts from the built-in catalog of a locale,
+// with optional per-message overrides
+type Messages struct {
+	locale string
+	text   map[string]*template.Template
+	html   map[string]*htmltemplate.Template
+}
+
+var templateFuncs = map[string]interface{}{
+	"upper": strings.ToUpper,
+	"json":  toJSON,
+	"short": shortChecksum,
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}
+
+// NewMessages compiles the catalog for locale; overrides replace individual
+// messages by name
+func NewMessages(locale string, overrides map[string]string) (*Messages, error) {
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	catalog, ok := catalogs[locale]
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale %q (available: %s)", locale, strings.Join(Locales(), ", "))
+	}
+
+	sources := make(map[string]string, len(catalog))
+	for name, src := range catalog {
+		sources[name] = src
+	}
+	for name, src := range overrides {
+		if _, ok := sources[name]; !ok {
+			return nil, fmt.Errorf("unknown message template %q", name)
+		}
+		sources[name] = src
+	}
+
+	m := &Messages{
+		locale: locale,
+		text:   make(map[string]*template.Template),
+		html:   make(map[string]*htmltemplate.Template),
+	}
+	funcs := make(map[string]interface{}, len(templateFuncs)+1)
+	for name, f := range templateFuncs {
+		funcs[name] = f
+	}
+	funcs["describe"] = m.describe
+
+	for name, src := range sources {
+		var err error
+		if strings.HasSuffix(name, ".html") {
+			m.html[name], err = htmltemplate.New(name).Funcs(funcs).Parse(src)
+		} else {
+			m.text[name], err = template.New(name).Funcs(funcs).Parse(src)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse message template %s: %w", name, err)
+		}
+	}
+
+	return m, nil
+}
+
+// DefaultMessages returns the built-in English catalog
+func DefaultMessages() *Messages {
+	m, err := NewMessages(DefaultLocale, nil)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Locales lists the built-in catalogs
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Render executes the named message for the given alerts
+func (m *Messages) Render(name string, alerts ...*models.Alert) (string, error) {
+	hostname, _ := os.Hostname()
+	data := MessageData{Alerts: alerts, Hostname: hostname, Now: time.Now()}
+	if len(alerts) > 0 {
+		data.Alert = alerts[0]
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if t, ok := m.html[name]; ok {
+		err = t.Execute(&buf, data)
+	} else if t, ok := m.text[name]; ok {
+		err = t.Execute(&buf, data)
+	} else {
+		return "", fmt.Errorf("unknown message template %q", name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// describe renders the kind.<kind> message of alert, which says what it
+// reports; the template's dot is the alert
+func (m *Messages) describe(alert *models.Alert) (string, error) {
+	kind := alert.Kind
+	if kind == "" {
+		kind = models.AlertMismatch
+	}
+	t, ok := m.text["kind."+kind]
+	if !ok {
+		return fmt.Sprintf("%s %s: %s", kind, alert.UtilityPath, alert.Message), nil
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, alert); err != nil {
+		return "", fmt.Errorf("failed to render kind.%s: %w", kind, err)
+	}
+	return buf.String(), nil
+}
+
+func shortChecksum(checksum string) string {
+	if len(checksum) <= 16 {
+		return checksum
+	}
+	return checksum[:16] + "..."
+}
+
+var catalogs = map[string]map[string]string{
+	"en": {
+		MsgKindMismatch:           `utility {{.UtilityPath}} modified (old: {{.OldChecksum}}, new: {{.NewChecksum}})`,
+		MsgKindBaselineUntrusted:  `baseline in {{.UtilityPath}} can no longer be trusted: {{.Message}}`,
+		MsgKindPackageUpdate:      `utility {{.UtilityPath}} {{.Message}} (old: {{.OldChecksum}}, new: {{.NewChecksum}})`,
+		MsgKindLockOverdue:        `package manager lock {{.UtilityPath}} {{.Message}}; checking changed files anyway`,
+		MsgKindMaintenanceExpired: `{{.Message}}; changes below {{.UtilityPath}} are alerted from now on`,
+		MsgKindSelfCheck:          `the monitor's own {{.Message}} ({{.UtilityPath}}); its alerts can no longer be trusted`,
+		MsgTTYAlert: `{{if eq .Alert.Kind "mismatch"}}
+╔══════════════════════════════════════════════════════════════╗
+║              ⚠️  SECURITY ALERT - UTILITY MODIFIED  ⚠️        ║
+╠══════════════════════════════════════════════════════════════╣
+║ Path:          {{.Alert.UtilityPath}}
+║ Severity:      {{upper .Alert.Severity}}
+║ Old Checksum:  {{short .Alert.OldChecksum}}
+║ New Checksum:  {{short .Alert.NewChecksum}}
+║ Detected At:   {{date .Alert.DetectedAt}}
+║
+║ WARNING: A system utility has been modified!
+║ This could indicate a security breach or malicious activity.
+║ Please investigate immediately!
+╚══════════════════════════════════════════════════════════════╝
+{{else}}
+╔══════════════════════════════════════════════════════════════╗
+║                  ⚠️  INTEGRITY MONITOR ALERT  ⚠️               ║
+╠══════════════════════════════════════════════════════════════╣
+║ {{describe .Alert}}
+║ Severity:      {{upper .Alert.Severity}}
+║ Detected At:   {{date .Alert.DetectedAt}}
+╚══════════════════════════════════════════════════════════════╝
+{{end}}`,
+		MsgLogAlert: `[{{date .Now}}] ALERT: {{.Alert.Severity}} - {{describe .Alert}}
+`,
+		MsgWebhookText: `[{{.Hostname}}] {{upper .Alert.Severity}}: {{describe .Alert}}`,
+		MsgEmailSubject: `{{if ne (len .Alerts) 1}}[{{.Hostname}}] {{len .Alerts}} integrity alerts` +
+			`{{else if eq .Alert.Kind "mismatch"}}[{{.Hostname}}] {{upper .Alert.Severity}}: {{.Alert.UtilityPath}} modified` +
+			`{{else}}[{{.Hostname}}] {{upper .Alert.Severity}}: {{describe .Alert}}{{end}}`,
+		MsgEmailText: `Integrity Monitor on {{.Hostname}} raised {{len .Alerts}} alerts.
+{{range .Alerts}}
+Path:          {{.UtilityPath}}
+Severity:      {{.Severity}}
+{{if eq .Kind "mismatch"}}Old Checksum:  {{.OldChecksum}}
+New Checksum:  {{.NewChecksum}}
+{{else}}Details:       {{describe .}}
+{{end}}Detected At:   {{date .DetectedAt}}
+{{end}}
+This could indicate a security breach or malicious activity.
+Please investigate immediately!
+`,
+		MsgEmailHTML: `<html><body>
+<h2>Integrity Monitor on {{.Hostname}}</h2>
+<p>{{len .Alerts}} alerts raised.</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Path</th><th>Severity</th><th>Old Checksum</th><th>New Checksum</th><th>Detected At</th></tr>
+{{range .Alerts}}<tr><td>{{.UtilityPath}}</td><td>{{.Severity}}</td>` +
+			`{{if eq .Kind "mismatch"}}<td><code>{{.OldChecksum}}</code></td><td><code>{{.NewChecksum}}</code></td>` +
+			`{{else}}<td colspan="2">{{describe .}}</td>{{end}}<td>{{date .DetectedAt}}</td></tr>
+{{end}}</table>
+<p><b>This could indicate a security breach or malicious activity. Please investigate immediately!</b></p>
+</body></html>
+`,
+	},
+	"ru": {
+		MsgKindMismatch:           `утилита {{.UtilityPath}} изменена (было: {{.OldChecksum}}, стало: {{.NewChecksum}})`,
+		MsgKindBaselineUntrusted:  `baseline в {{.UtilityPath}} больше нельзя доверять: {{.Message}}`,
+		MsgKindPackageUpdate:      `утилита {{.UtilityPath}} обновлена пакетным менеджером: {{.Message}} (было: {{.OldChecksum}}, стало: {{.NewChecksum}})`,
+		MsgKindLockOverdue:        `блокировка пакетного менеджера {{.UtilityPath}} удерживается слишком долго: {{.Message}}; изменённые файлы проверяются`,
+		MsgKindMaintenanceExpired: `окно обслуживания истекло без одобрения изменений: {{.Message}}; изменения в {{.UtilityPath}} снова вызывают alert`,
+		MsgKindSelfCheck:          `самопроверка монитора не прошла: {{.Message}} ({{.UtilityPath}}); его alert больше нельзя доверять`,
+		MsgTTYAlert: `{{if eq .Alert.Kind "mismatch"}}
+╔══════════════════════════════════════════════════════════════╗
+║           ⚠️  УГРОЗА БЕЗОПАСНОСТИ - УТИЛИТА ИЗМЕНЕНА  ⚠️       ║
+╠══════════════════════════════════════════════════════════════╣
+║ Путь:              {{.Alert.UtilityPath}}
+║ Критичность:       {{upper .Alert.Severity}}
+║ Старая сумма:      {{short .Alert.OldChecksum}}
+║ Новая сумма:       {{short .Alert.NewChecksum}}
+║ Обнаружено:        {{date .Alert.DetectedAt}}
+║
+║ ВНИМАНИЕ: системная утилита была изменена!
+║ Это может означать взлом или вредоносную активность.
+║ Немедленно проведите расследование!
+╚══════════════════════════════════════════════════════════════╝
+{{else}}
+╔══════════════════════════════════════════════════════════════╗
+║              ⚠️  ПРЕДУПРЕЖДЕНИЕ INTEGRITY MONITOR  ⚠️          ║
+╠══════════════════════════════════════════════════════════════╣
+║ {{describe .Alert}}
+║ Критичность:       {{upper .Alert.Severity}}
+║ Обнаружено:        {{date .Alert.DetectedAt}}
+╚══════════════════════════════════════════════════════════════╝
+{{end}}`,
+		MsgLogAlert: `[{{date .Now}}] ALERT: {{.Alert.Severity}} - {{describe .Alert}}
+`,
+		MsgWebhookText: `[{{.Hostname}}] {{upper .Alert.Severity}}: {{describe .Alert}}`,
+		MsgEmailSubject: `{{if ne (len .Alerts) 1}}[{{.Hostname}}] предупреждений целостности: {{len .Alerts}}` +
+			`{{else if eq .Alert.Kind "mismatch"}}[{{.Hostname}}] {{upper .Alert.Severity}}: изменена утилита {{.Alert.UtilityPath}}` +
+			`{{else}}[{{.Hostname}}] {{upper .Alert.Severity}}: {{describe .Alert}}{{end}}`,
+		MsgEmailText: `Integrity Monitor на {{.Hostname}}: предупреждений {{len .Alerts}}.
+{{range .Alerts}}
+Путь:           {{.UtilityPath}}
+Критичность:    {{.Severity}}
+{{if eq .Kind "mismatch"}}Старая сумма:   {{.OldChecksum}}
+Новая сумма:    {{.NewChecksum}}
+{{else}}Подробности:    {{describe .}}
+{{end}}Обнаружено:     {{date .DetectedAt}}
+{{end}}
+Это может означать взлом или вредоносную активность.
+Немедленно проведите расследование!
+`,
+		MsgEmailHTML: `<html><body>
+<h2>Integrity Monitor на {{.Hostname}}</h2>
+<p>Предупреждений: {{len .Alerts}}.</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Путь</th><th>Критичность</th><th>Старая сумма</th><th>Новая сумма</th><th>Обнаружено</th></tr>
+{{range .Alerts}}<tr><td>{{.UtilityPath}}</td><td>{{.Severity}}</td>` +
+			`{{if eq .Kind "mismatch"}}<td><code>{{.OldChecksum}}</code></td><td><code>{{.NewChecksum}}</code></td>` +
+			`{{else}}<td colspan="2">{{describe .}}</td>{{end}}<td>{{date .DetectedAt}}</td></tr>
+{{end}}</table>
+<p><b>Это может означать взлом или вредоносную активность. Немедленно проведите расследование!</b></p>
+</body></html>
+`,
+	},
+}
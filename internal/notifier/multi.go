@@ -0,0 +1,70 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+
+	"integrity-monitor/pkg/models"
+)
+
+// MultiNotifier fans an alert out to several notifiers
+type MultiNotifier struct {
+	notifiers []Notifier
+}
+
+func NewMultiNotifier(notifiers ...Notifier) *MultiNotifier {
+	return &MultiNotifier{notifiers: notifiers}
+}
+
+// SendAlert delivers the alert to every notifier, even if some of them fail
+func (m *MultiNotifier) SendAlert(ctx context.Context, alert *models.Alert) error {
+	var errs []error
+	for _, n := range m.notifiers {
+		if err := n.SendAlert(ctx, alert); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Batching reports whether any of the notifiers buffers alerts
+func (m *MultiNotifier) Batching() bool {
+	for _, n := range m.notifiers {
+		if b, ok := n.(BatchNotifier); ok && b.Batching() {
+			return true
+		}
+	}
+	return false
+}
+
+// Immediate reports whether any batching notifier sends alert right away
+func (m *MultiNotifier) Immediate(alert *models.Alert) bool {
+	for _, n := range m.notifiers {
+		if b, ok := n.(BatchNotifier); ok && b.Batching() && b.Immediate(alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginBatch forwards to every notifier that supports batching
+func (m *MultiNotifier) BeginBatch() {
+	for _, n := range m.notifiers {
+		if b, ok := n.(BatchNotifier); ok {
+			b.BeginBatch()
+		}
+	}
+}
+
+// EndBatch flushes every notifier that supports batching
+func (m *MultiNotifier) EndBatch(ctx context.Context) error {
+	var errs []error
+	for _, n := range m.notifiers {
+		if b, ok := n.(BatchNotifier); ok {
+			if err := b.EndBatch(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,8 +1,27 @@
 package notifier
 
-import "integrity-monitor/pkg/models"
+import (
+	"context"
 
-// Notifier defines the interface for sending alerts
+	"integrity-monitor/pkg/models"
+)
+
+// Notifier defines the interface for sending alerts. Deliveries in
+// progress give up when ctx is cancelled.
 type Notifier interface {
-	SendAlert(alert *models.Alert) error
+	SendAlert(ctx context.Context, alert *models.Alert) error
+}
+
+// BatchNotifier is implemented by notifiers that can group the alerts of
+// one periodic scan into a single delivery
+type BatchNotifier interface {
+	Notifier
+	// Batching reports whether SendAlert buffers alerts between BeginBatch
+	// and EndBatch rather than delivering them right away
+	Batching() bool
+	// Immediate reports whether alert skips the digest and is delivered as
+	// soon as it is raised, even while a scan holds deliveries back
+	Immediate(alert *models.Alert) bool
+	BeginBatch()
+	EndBatch(ctx context.Context) error
 }
@@ -1,49 +1,50 @@
 package notifier
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/user"
 
 	"integrity-monitor/pkg/models"
 )
 
+// TTYOptions selects which terminals receive alerts
+type TTYOptions struct {
+	// UtmpFile lists the login sessions to write to
+	UtmpFile string
+	// Groups restricts alerts to users in any of these groups, like wall -g;
+	// empty means every logged-in user
+	Groups []string
+	// Messages renders the alert banner
+	Messages *Messages
+}
+
 type TTYNotifier struct {
 	logFile string
+	opts    TTYOptions
 }
 
-func NewTTYNotifier(logFile string) *TTYNotifier {
-	return &TTYNotifier{logFile: logFile}
+func NewTTYNotifier(logFile string, opts TTYOptions) *TTYNotifier {
+	if opts.UtmpFile == "" {
+		opts.UtmpFile = DefaultUtmpFile
+	}
+	if opts.Messages == nil {
+		opts.Messages = DefaultMessages()
+	}
+	return &TTYNotifier{logFile: logFile, opts: opts}
 }
 
-func (n *TTYNotifier) SendAlert(alert *models.Alert) error {
-	message := fmt.Sprintf(`
-╔══════════════════════════════════════════════════════════════╗
-║              ⚠️  SECURITY ALERT - UTILITY MODIFIED  ⚠️        ║
-╠══════════════════════════════════════════════════════════════╣
-║ Path:          %s
-║ Severity:      %s
-║ Old Checksum:  %s
-║ New Checksum:  %s
-║ Detected At:   %s
-║
-║ WARNING: A system utility has been modified!
-║ This could indicate a security breach or malicious activity.
-║ Please investigate immediately!
-╚══════════════════════════════════════════════════════════════╝
-`,
-		alert.UtilityPath,
-		strings.ToUpper(alert.Severity),
-		alert.OldChecksum[:16]+"...",
-		alert.NewChecksum[:16]+"...",
-		alert.DetectedAt.Format("2006-01-02 15:04:05"),
-	)
-
-	// Log to file
-	if err := n.logToFile(message); err != nil {
+func (n *TTYNotifier) SendAlert(ctx context.Context, alert *models.Alert) error {
+	message, err := n.opts.Messages.Render(MsgTTYAlert, alert)
+	if err != nil {
+		return err
+	}
+
+	// Log to file, with the chain hash so the log can be matched against the database
+	if err := n.logToFile(message + fmt.Sprintf("[alert #%d chain %s]\n", alert.ID, alert.Hash)); err != nil {
 		log.Printf("Failed to log alert to file: %v", err)
 	}
 
@@ -67,21 +68,9 @@ func (n *TTYNotifier) logToFile(message string) error {
 }
 
 func (n *TTYNotifier) broadcastToTTYs(message string) error {
-	// Find all TTY and PTS devices
-	ttys := []string{}
-
-	// Check /dev/tty*
-	ttyMatches, _ := filepath.Glob("/dev/tty[0-9]*")
-	ttys = append(ttys, ttyMatches...)
-
-	// Check /dev/pts/*
-	ptsDir := "/dev/pts"
-	if entries, err := os.ReadDir(ptsDir); err == nil {
-		for _, entry := range entries {
-			if !entry.IsDir() && entry.Name() != "ptmx" {
-				ttys = append(ttys, filepath.Join(ptsDir, entry.Name()))
-			}
-		}
+	ttys, err := n.targetTTYs()
+	if err != nil {
+		return err
 	}
 
 	// Try to write to each TTY
@@ -100,6 +89,65 @@ func (n *TTYNotifier) broadcastToTTYs(message string) error {
 	return nil
 }
 
+// targetTTYs returns the terminals of logged-in users allowed to see alerts
+func (n *TTYNotifier) targetTTYs() ([]string, error) {
+	sessions, err := ReadSessions(n.opts.UtmpFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login sessions: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	seen := make(map[string]bool)
+	var ttys []string
+	for _, session := range sessions {
+		if seen[session.TTY] {
+			continue
+		}
+
+		ok, checked := allowed[session.User]
+		if !checked {
+			ok = n.userAllowed(session.User)
+			allowed[session.User] = ok
+		}
+		if !ok {
+			continue
+		}
+
+		seen[session.TTY] = true
+		ttys = append(ttys, session.TTY)
+	}
+
+	return ttys, nil
+}
+
+func (n *TTYNotifier) userAllowed(username string) bool {
+	if len(n.opts.Groups) == 0 {
+		return true
+	}
+
+	u, err := user.Lookup(username)
+	if err != nil {
+		return false
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+
+	for _, gid := range gids {
+		group, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		for _, name := range n.opts.Groups {
+			if group.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (n *TTYNotifier) writeToTTY(ttyPath string, message string) error {
 	// Check if we have write permission
 	info, err := os.Stat(ttyPath)
@@ -0,0 +1,95 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultUtmpFile is where glibc records current login sessions
+const DefaultUtmpFile = "/run/utmp"
+
+// utmpUserProcess is ut_type of a normal login session
+const utmpUserProcess = 7
+
+// utmpRecord mirrors struct utmp from <utmp.h> on Linux (384 bytes)
+type utmpRecord struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	TvSec   int32
+	TvUsec  int32
+	AddrV6  [4]int32
+	_       [20]byte
+}
+
+// Session is a login session read from utmp
+type Session struct {
+	User string
+	TTY  string // device path, e.g. /dev/pts/3
+	Host string
+	Pid  int
+}
+
+// ReadSessions returns the live login sessions recorded in a utmp file
+func ReadSessions(utmpFile string) ([]Session, error) {
+	f, err := os.Open(utmpFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sessions []Session
+	for {
+		var rec utmpRecord
+		if err := binary.Read(f, binary.LittleEndian, &rec); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse %s: %w", utmpFile, err)
+		}
+
+		if rec.Type != utmpUserProcess {
+			continue
+		}
+
+		line := cString(rec.Line[:])
+		if line == "" || strings.Contains(line, "..") {
+			continue
+		}
+
+		// Skip stale entries left behind by sessions that did not log out
+		if rec.Pid > 0 {
+			if _, err := os.Stat(fmt.Sprintf("/proc/%d", rec.Pid)); err != nil {
+				continue
+			}
+		}
+
+		sessions = append(sessions, Session{
+			User: cString(rec.User[:]),
+			TTY:  filepath.Join("/dev", line),
+			Host: cString(rec.Host[:]),
+			Pid:  int(rec.Pid),
+		})
+	}
+
+	return sessions, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,194 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"integrity-monitor/pkg/models"
+)
+
+// DefaultWebhookTemplate produces a Slack/Mattermost compatible payload
+const DefaultWebhookTemplate = `{"text": {{json .Text}}, "alert": {{json .Alert}}, "host": {{json .Hostname}}}`
+
+// WebhookOptions describes a single HTTP endpoint receiving alerts
+type WebhookOptions struct {
+	URL             string
+	Method          string
+	Headers         map[string]string
+	BodyTemplate    string
+	Secret          string
+	SignatureHeader string
+	Timeout         time.Duration
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	// Messages renders the .Text field of the payload
+	Messages *Messages
+}
+
+// WebhookPayload is the data passed to the body template
+type WebhookPayload struct {
+	Alert    *models.Alert
+	Hostname string
+	Text     string
+}
+
+type WebhookNotifier struct {
+	opts     WebhookOptions
+	tmpl     *template.Template
+	client   *http.Client
+	hostname string
+}
+
+func NewWebhookNotifier(opts WebhookOptions) (*WebhookNotifier, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+	if opts.BodyTemplate == "" {
+		opts.BodyTemplate = DefaultWebhookTemplate
+	}
+	if opts.SignatureHeader == "" {
+		opts.SignatureHeader = "X-Integrity-Signature"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	if opts.Messages == nil {
+		opts.Messages = DefaultMessages()
+	}
+
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(opts.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook body template: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &WebhookNotifier{
+		opts:     opts,
+		tmpl:     tmpl,
+		client:   &http.Client{Timeout: opts.Timeout},
+		hostname: hostname,
+	}, nil
+}
+
+func (n *WebhookNotifier) SendAlert(ctx context.Context, alert *models.Alert) error {
+	body, err := n.render(alert)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	backoff := n.opts.RetryBackoff
+	for attempt := 0; attempt <= n.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying webhook %s in %s (attempt %d/%d): %v",
+				n.opts.URL, backoff, attempt, n.opts.MaxRetries, lastErr)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("webhook %s failed: %w", n.opts.URL, ctx.Err())
+			}
+			backoff *= 2
+		}
+
+		retry, err := n.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return fmt.Errorf("webhook %s failed: %w", n.opts.URL, lastErr)
+}
+
+func (n *WebhookNotifier) render(alert *models.Alert) ([]byte, error) {
+	text, err := n.opts.Messages.Render(MsgWebhookText, alert)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := WebhookPayload{
+		Alert:    alert,
+		Hostname: n.hostname,
+		Text:     text,
+	}
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook body: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one request and reports whether a failure is worth retrying
+func (n *WebhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, n.opts.Method, n.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "integrity-monitor")
+	for key, value := range n.opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if n.opts.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Integrity-Timestamp", timestamp)
+		req.Header.Set(n.opts.SignatureHeader, "sha256="+SignPayload(n.opts.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// SignPayload computes the hex HMAC-SHA256 of "timestamp.body" so receivers
+// can authenticate the request and reject replays
+func SignPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
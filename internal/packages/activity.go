@@ -0,0 +1,337 @@
+package packages
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"integrity-monitor/internal/rootfs"
+)
+
+// Maintenance modes for files changed by the package manager
+const (
+	MaintenanceOff       = "off"
+	MaintenanceDowngrade = "downgrade"
+)
+
+// Defaults for MaintenanceOptions
+var (
+	DefaultLocks    = []string{"/var/lib/dpkg/lock-frontend", "/var/lib/dpkg/lock"}
+	DefaultLogs     = []string{"/var/log/dpkg.log", "/var/log/apt/history.log"}
+	DefaultMaxDefer = time.Hour
+)
+
+// MaintenanceOptions configures recognition of package manager activity
+type MaintenanceOptions struct {
+	Locks []string // fcntl lock files held while the package manager runs
+	Logs  []string // dpkg.log and apt history.log files
+	// MaxDefer bounds how long held locks defer checks, however many
+	// processes take turns holding them, so that a stuck or malicious
+	// holder cannot blind the monitor
+	MaxDefer time.Duration
+}
+
+// LockHolder is a process holding a package manager lock
+type LockHolder struct {
+	Path string
+	PID  int
+	// Since is when the locks were first seen held without being seen free
+	// since: the start of the first holder process, or when it was seen
+	Since time.Time
+}
+
+// Maintenance tells changes made by the package manager apart from
+// tampering. A changed file counts as a legitimate update only when a
+// package log shows its owning package was installed after the baseline
+// was recorded and the file matches the digest of that package.
+type Maintenance struct {
+	root rootfs.Root
+	opts MaintenanceOptions
+
+	mu        sync.Mutex
+	db        *Database
+	dbStamp   time.Time
+	installs  map[string]time.Time // package -> last installation
+	logStamps map[string]time.Time
+	holder    *LockHolder
+}
+
+func NewMaintenance(root rootfs.Root, opts MaintenanceOptions) *Maintenance {
+	if opts.Locks == nil {
+		opts.Locks = DefaultLocks
+	}
+	if opts.Logs == nil {
+		opts.Logs = DefaultLogs
+	}
+	if opts.MaxDefer <= 0 {
+		opts.MaxDefer = DefaultMaxDefer
+	}
+	return &Maintenance{root: root, opts: opts}
+}
+
+// MaxDefer is how long held locks may defer checks
+func (m *Maintenance) MaxDefer() time.Duration {
+	return m.opts.MaxDefer
+}
+
+// Holder returns the process holding one of the package manager locks, in
+// which case files may be half-installed and should be checked later, or
+// nil
+func (m *Maintenance) Holder() *LockHolder {
+	var current *LockHolder
+	for _, lock := range m.opts.Locks {
+		path, err := m.root.Resolve(lock)
+		if err != nil {
+			continue
+		}
+		if pid, err := lockHolder(path); err == nil && pid != 0 {
+			current = &LockHolder{Path: lock, PID: pid}
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case current == nil:
+		m.holder = nil
+		return nil
+	case m.holder == nil:
+		// The start of the process bounds how long it can have held the
+		// lock and, unlike the first sighting, survives one-shot scans
+		current.Since = time.Now()
+		if started, err := processStart(current.PID); err == nil {
+			current.Since = started
+		}
+		m.holder = current
+	case m.holder.PID != current.PID:
+		// Another process took over without the locks being seen free, so
+		// the deferral goes on from when it began
+		current.Since = m.holder.Since
+		m.holder = current
+	}
+	holder := *m.holder
+	return &holder
+}
+
+// lockHolder asks the kernel which process holds an fcntl lock on path, as
+// dpkg and apt do, without taking the lock ourselves; 0 means none
+func lockHolder(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0, Start: 0, Len: 0}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lock); err != nil {
+		return 0, err
+	}
+	if lock.Type == syscall.F_UNLCK {
+		return 0, nil
+	}
+	return int(lock.Pid), nil
+}
+
+// processStart reads when pid was started from /proc/<pid>/stat, whose
+// 22nd field counts clock ticks (USER_HZ, 100 on Linux) since boot
+func processStart(pid int) (time.Time, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The command name in parentheses may contain spaces
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return time.Time{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / 100), nil
+}
+
+// bootTime reads the btime line of /proc/stat
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// Owner returns the package owning path according to the package
+// database, or nil
+func (m *Maintenance) Owner(path string) *Owner {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.refresh(); err != nil {
+		log.Printf("Warning: failed to read package manager state: %v", err)
+		return nil
+	}
+	return m.db.Owner(path)
+}
+
+// Installed returns the owner of path when the package logs show it was
+// installed after since and the file matches the package's digest
+func (m *Maintenance) Installed(path string, since time.Time) (*Owner, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.refresh(); err != nil {
+		log.Printf("Warning: failed to read package manager state: %v", err)
+		return nil, false
+	}
+
+	owner := m.db.Owner(path)
+	if owner == nil {
+		return nil, false
+	}
+	if installed, ok := m.installs[owner.Package]; !ok || installed.Before(since) {
+		return owner, false
+	}
+
+	hostPath, err := m.root.Resolve(path)
+	if err != nil {
+		return owner, false
+	}
+	digest, err := FileDigest(hostPath, owner.Algorithm)
+	if err != nil || digest != owner.Digest {
+		return owner, false
+	}
+	return owner, true
+}
+
+// refresh reloads the package database and logs when they changed on disk
+func (m *Maintenance) refresh() error {
+	if stamp := m.databaseStamp(); m.db == nil || !stamp.Equal(m.dbStamp) {
+		db, err := Load(m.root)
+		if err != nil {
+			return err
+		}
+		m.db, m.dbStamp = db, stamp
+	}
+
+	changed := m.installs == nil
+	stamps := make(map[string]time.Time, len(m.opts.Logs))
+	for _, file := range m.opts.Logs {
+		if path, err := m.root.Resolve(file); err == nil {
+			if info, err := os.Stat(path); err == nil {
+				stamps[file] = info.ModTime()
+			}
+		}
+		if !stamps[file].Equal(m.logStamps[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	installs := make(map[string]time.Time)
+	for _, file := range m.opts.Logs {
+		if path, err := m.root.Resolve(file); err == nil {
+			readInstallLog(path, installs)
+		}
+	}
+	m.installs, m.logStamps = installs, stamps
+	return nil
+}
+
+// databaseStamp changes whenever dpkg or rpm commits a transaction
+func (m *Maintenance) databaseStamp() time.Time {
+	var latest time.Time
+	for _, file := range []string{dpkgStatusFile, rpmDBDir + "/rpmdb.sqlite", rpmDBDir + "/Packages"} {
+		path, err := m.root.Resolve(file)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// readInstallLog records the latest installation time of each package from
+// a dpkg.log ("<date> <time> status installed <pkg>:<arch> <version>") or an
+// apt history.log (Install/Upgrade/Reinstall lines closed by End-Date)
+func readInstallLog(path string, installs map[string]time.Time) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var pending []string
+	record := func(pkg string, at time.Time) {
+		pkg, _, _ = strings.Cut(pkg, ":")
+		if at.After(installs[pkg]) {
+			installs[pkg] = at
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+
+		switch {
+		case strings.HasPrefix(line, "Start-Date: "):
+			pending = nil
+		case len(fields) >= 5 && fields[2] == "status" && fields[3] == "installed":
+			if at, err := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.Local); err == nil {
+				record(fields[4], at)
+			}
+		case strings.HasPrefix(line, "Install: "), strings.HasPrefix(line, "Upgrade: "),
+			strings.HasPrefix(line, "Reinstall: "), strings.HasPrefix(line, "Downgrade: "):
+			_, list, _ := strings.Cut(line, ": ")
+			pending = append(pending, aptPackages(list)...)
+		case strings.HasPrefix(line, "End-Date: ") && len(fields) == 3:
+			if at, err := time.ParseInLocation("2006-01-02 15:04:05", fields[1]+" "+fields[2], time.Local); err == nil {
+				for _, pkg := range pending {
+					record(pkg, at)
+				}
+			}
+			pending = nil
+		}
+	}
+}
+
+// aptPackages extracts names from "a:amd64 (1.0, 1.1), b:amd64 (2.0)"
+func aptPackages(list string) []string {
+	var names []string
+	for _, item := range strings.Split(list, "), ") {
+		if name, _, ok := strings.Cut(strings.TrimSpace(item), " "); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
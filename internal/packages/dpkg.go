@@ -0,0 +1,122 @@
+package packages
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"integrity-monitor/internal/rootfs"
+)
+
+const (
+	dpkgInfoDir    = "/var/lib/dpkg/info"
+	dpkgStatusFile = "/var/lib/dpkg/status"
+)
+
+// loadDpkg reads info/<package>[:<arch>].md5sums, whose lines hold an MD5
+// and a path relative to /
+func (db *Database) loadDpkg(root rootfs.Root, infoDir string) error {
+	versions, err := dpkgVersions(root)
+	if err != nil {
+		return err
+	}
+
+	sums, err := filepath.Glob(filepath.Join(infoDir, "*.md5sums"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range sums {
+		name := strings.TrimSuffix(filepath.Base(file), ".md5sums")
+		pkg, _, _ := strings.Cut(name, ":")
+		version, installed := versions[name]
+		if !installed {
+			// Leftovers of removed packages still in "config-files" state
+			continue
+		}
+
+		if err := db.readMD5Sums(file, &Owner{
+			Manager:   "dpkg",
+			Package:   pkg,
+			Version:   version,
+			Algorithm: "md5",
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) readMD5Sums(file string, template *Owner) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		digest, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		owner := *template
+		owner.Digest = digest
+		db.add("/"+strings.TrimPrefix(path, "/"), &owner)
+	}
+	return scanner.Err()
+}
+
+// dpkgVersions returns the versions of installed packages, keyed both by
+// name and by name:arch since md5sums files of Multi-Arch: same packages
+// carry the architecture
+func dpkgVersions(root rootfs.Root) (map[string]string, error) {
+	statusPath, err := root.Resolve(dpkgStatusFile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	versions := make(map[string]string)
+	var pkg, arch, version, status string
+	flush := func() {
+		if pkg != "" && strings.HasSuffix(status, " installed") {
+			versions[pkg] = version
+			versions[pkg+":"+arch] = version
+		}
+		pkg, arch, version, status = "", "", "", ""
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Package":
+			pkg = value
+		case "Architecture":
+			arch = value
+		case "Version":
+			version = value
+		case "Status":
+			status = value
+		}
+	}
+	flush()
+
+	return versions, scanner.Err()
+}
@@ -0,0 +1,149 @@
+package packages
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"integrity-monitor/internal/metrics"
+	"integrity-monitor/internal/rootfs"
+)
+
+// Owner is the package that installed a file, with the digest the package
+// manager recorded for it
+type Owner struct {
+	Manager   string // dpkg or rpm
+	Package   string
+	Version   string
+	Algorithm string // md5, sha1, sha256 or sha512
+	Digest    string
+}
+
+// Database maps installed files to their owning packages
+type Database struct {
+	files map[string]*Owner
+}
+
+// Load reads the databases of every package manager present under root
+func Load(root rootfs.Root) (*Database, error) {
+	db := &Database{files: make(map[string]*Owner)}
+
+	loaded := false
+	if dir, err := root.Resolve(dpkgInfoDir); err == nil {
+		if err := db.loadDpkg(root, dir); err != nil {
+			return nil, fmt.Errorf("failed to read dpkg database: %w", err)
+		}
+		loaded = true
+	}
+	if _, err := root.Resolve(rpmDBDir); err == nil {
+		if err := db.loadRPM(root); err != nil {
+			// The rpm database needs the rpm tool; dpkg data is still usable
+			log.Printf("Warning: failed to read rpm database: %v", err)
+		} else {
+			loaded = true
+		}
+	}
+
+	if !loaded {
+		return nil, fmt.Errorf("no dpkg or rpm database found under %s", root)
+	}
+	return db, nil
+}
+
+// Len returns the number of files with a known owner
+func (db *Database) Len() int {
+	return len(db.files)
+}
+
+// Owner returns the package owning path, or nil. On merged-/usr systems
+// packages may list /bin/ls while the file is scanned as /usr/bin/ls, so
+// both spellings are tried.
+func (db *Database) Owner(path string) *Owner {
+	if owner, ok := db.files[path]; ok {
+		return owner
+	}
+	if alias := usrMergeAlias(path); alias != "" {
+		return db.files[alias]
+	}
+	return nil
+}
+
+func (db *Database) add(path string, owner *Owner) {
+	// Directories and files without a recorded digest cannot be verified
+	if owner.Digest == "" {
+		return
+	}
+	db.files[path] = owner
+}
+
+// usrMergeAlias maps /usr/bin/x to /bin/x and back
+func usrMergeAlias(path string) string {
+	for _, dir := range []string{"/bin/", "/sbin/", "/lib/", "/lib32/", "/lib64/", "/libx32/"} {
+		if strings.HasPrefix(path, "/usr"+dir) {
+			return strings.TrimPrefix(path, "/usr")
+		}
+		if strings.HasPrefix(path, dir) {
+			return "/usr" + path
+		}
+	}
+	return ""
+}
+
+// FileDigest computes the hex digest of a file with a package manager algorithm
+func FileDigest(path, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(h, f)
+	metrics.BytesHashed.Add(float64(n))
+	if err != nil {
+		return "", err
+	}
+	metrics.FilesHashed.Inc()
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify compares the file at path of the system under root with the
+// digest recorded by its package. It returns the owner (nil for files no
+// package owns) and the file's actual digest.
+func (db *Database) Verify(root rootfs.Root, path string) (*Owner, string, error) {
+	owner := db.Owner(path)
+	if owner == nil {
+		return nil, "", nil
+	}
+
+	hostPath, err := root.Resolve(path)
+	if err != nil {
+		return owner, "", err
+	}
+	digest, err := FileDigest(hostPath, owner.Algorithm)
+	if err != nil {
+		return owner, "", err
+	}
+	return owner, digest, nil
+}
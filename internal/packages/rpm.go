@@ -0,0 +1,77 @@
+package packages
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"integrity-monitor/internal/rootfs"
+)
+
+const rpmDBDir = "/var/lib/rpm"
+
+// rpmQueryFormat prints one line per file; %{=TAG} repeats a package-level
+// tag for every file of the package
+const rpmQueryFormat = `[%{FILENAMES}\t%{FILEDIGESTS}\t%{=FILEDIGESTALGO}\t%{=NAME}\t%{=VERSION}-%{=RELEASE}\n]`
+
+// rpmDigestAlgorithms maps PGPHASHALGO values; packages without the tag use MD5
+var rpmDigestAlgorithms = map[string]string{
+	"(none)": "md5",
+	"1":      "md5",
+	"2":      "sha1",
+	"8":      "sha256",
+	"10":     "sha512",
+}
+
+// loadRPM queries the rpm tool, since the database format (Berkeley DB,
+// NDB or SQLite) depends on the distribution release
+func (db *Database) loadRPM(root rootfs.Root) error {
+	rpm, err := exec.LookPath("rpm")
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-qa", "--qf", rpmQueryFormat}
+	if !root.IsHost() {
+		args = append([]string{"--root", root.String()}, args...)
+	}
+	cmd := exec.Command(rpm, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		algorithm, ok := rpmDigestAlgorithms[fields[2]]
+		if !ok {
+			continue
+		}
+		db.add(fields[0], &Owner{
+			Manager:   "rpm",
+			Package:   fields[3],
+			Version:   fields[4],
+			Algorithm: algorithm,
+			Digest:    fields[1],
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		// Stop rpm rather than block on a pipe nobody reads
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("rpm query failed: %w", err)
+	}
+	return nil
+}
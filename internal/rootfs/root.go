@@ -0,0 +1,113 @@
+package rootfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks bounds symlink resolution, like the kernel's ELOOP limit
+const maxSymlinks = 40
+
+// Root maps the absolute paths of a monitored system onto the directory
+// holding its filesystem, such as a mounted disk image or a container
+// rootfs. The zero value is the running host.
+type Root struct {
+	dir string
+}
+
+// New returns the root for dir; "" and "/" mean the running host
+func New(dir string) (Root, error) {
+	if dir == "" || dir == "/" {
+		return Root{}, nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return Root{}, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return Root{}, err
+	}
+	if !info.IsDir() {
+		return Root{}, fmt.Errorf("%s is not a directory", abs)
+	}
+
+	return Root{dir: abs}, nil
+}
+
+// IsHost reports whether paths refer to the running system
+func (r Root) IsHost() bool {
+	return r.dir == ""
+}
+
+func (r Root) String() string {
+	if r.IsHost() {
+		return "/"
+	}
+	return r.dir
+}
+
+// Resolve returns the host path of a logical path. Symlinks are followed
+// inside the root, so an absolute link in an image never escapes to the
+// host's own files. On the host the path is returned unchanged.
+func (r Root) Resolve(logical string) (string, error) {
+	if r.IsHost() {
+		return logical, nil
+	}
+
+	resolved := "/"
+	rest := strings.Split(filepath.Clean("/"+logical), "/")
+	links := 0
+
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		host := filepath.Join(r.dir, next)
+		info, err := os.Lstat(host)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", logical)
+		}
+
+		target, err := os.Readlink(host)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return filepath.Join(r.dir, resolved), nil
+}
+
+// Stat follows symlinks inside the root
+func (r Root) Stat(logical string) (os.FileInfo, error) {
+	host, err := r.Resolve(logical)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(host)
+}
@@ -1,26 +1,51 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"integrity-monitor/internal/health"
+	"integrity-monitor/internal/rootfs"
 )
 
 type Scanner struct {
+	mu    sync.Mutex
 	paths []string
+	root  rootfs.Root
+}
+
+// NewScanner scans paths of the system found at root; results are always
+// reported as paths of that system, e.g. /usr/bin/ls
+func NewScanner(paths []string, root rootfs.Root) *Scanner {
+	return &Scanner{paths: paths, root: root}
 }
 
-func NewScanner(paths []string) *Scanner {
-	return &Scanner{paths: paths}
+// SetPaths replaces the monitored directories; a scan in progress finishes
+// with the old ones
+func (s *Scanner) SetPaths(paths []string) {
+	s.mu.Lock()
+	s.paths = paths
+	s.mu.Unlock()
 }
 
-// ScanAll returns all executable files in monitored directories
-func (s *Scanner) ScanAll() ([]string, error) {
+// ScanAll returns all executable files in monitored directories. It
+// stops with ctx's error when ctx is cancelled.
+func (s *Scanner) ScanAll(ctx context.Context) ([]string, error) {
 	var utilities []string
 	seen := make(map[string]bool)
 
-	for _, path := range s.paths {
-		files, err := s.scanDirectory(path)
+	s.mu.Lock()
+	paths := s.paths
+	s.mu.Unlock()
+
+	for _, path := range paths {
+		files, err := s.scanDirectory(ctx, path)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err != nil {
 			// Log error but continue with other directories
 			fmt.Printf("Warning: failed to scan %s: %v\n", path, err)
@@ -38,10 +63,18 @@ func (s *Scanner) ScanAll() ([]string, error) {
 	return utilities, nil
 }
 
-func (s *Scanner) scanDirectory(dir string) ([]string, error) {
+func (s *Scanner) scanDirectory(ctx context.Context, dir string) ([]string, error) {
 	var files []string
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	hostDir, err := s.root.Resolve(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve directory %s: %w", dir, err)
+	}
+
+	err = filepath.Walk(hostDir, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
 			// Skip directories we can't access
 			if os.IsPermission(err) {
@@ -50,14 +83,19 @@ func (s *Scanner) scanDirectory(dir string) ([]string, error) {
 			return err
 		}
 
-		// Skip directories
+		// Skip directories; entering one is progress of a long walk
 		if info.IsDir() {
+			health.Beat(ctx)
 			return nil
 		}
 
 		// Check if file is executable
 		if isExecutable(info) {
-			files = append(files, path)
+			rel, err := filepath.Rel(hostDir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.Join(dir, rel))
 		}
 
 		return nil
@@ -0,0 +1,251 @@
+// Package selfcheck pins the monitor's own executable, configuration and
+// baseline in a signed manifest, so that an attacker who disables
+// monitoring by patching them is noticed.
+package selfcheck
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"integrity-monitor/internal/baseline"
+	"integrity-monitor/internal/checksum"
+	"integrity-monitor/internal/config"
+)
+
+// Identifiers of the manifest format
+const (
+	FormatName    = "integrity-monitor-self-manifest"
+	FormatVersion = 1
+)
+
+// RunningExecutable is the image of the running process, which stays the
+// one that was started even if the file on disk is replaced
+const RunningExecutable = "/proc/self/exe"
+
+// Kinds of pinned state
+const (
+	KindExecutable  = "executable"
+	KindConfig      = "config"
+	KindBaseline    = "baseline"
+	KindEnvironment = "environment" // INTEGRITY_MONITOR_* overrides
+	KindManifest    = "manifest"    // the manifest itself is missing or forged
+)
+
+// Manifest pins the digests of the monitor's own files. The database changes
+// with every scan, so what is pinned for it is the Merkle root of the
+// baseline it holds. Environment overrides are pinned by digest, since
+// they may hold secrets.
+type Manifest struct {
+	Format       string              `json:"format"`
+	Version      int                 `json:"version"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Host         string              `json:"host"`
+	Executable   File                `json:"executable"`
+	Config       []File              `json:"config"`
+	Environment  string              `json:"environment,omitempty"` // see EnvironmentDigest
+	Database     string              `json:"database"`
+	BaselineRoot string              `json:"baseline_root"`
+	Signature    *baseline.Signature `json:"signature,omitempty"`
+}
+
+// File is one pinned file
+type File struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+// State describes the files to pin or check
+type State struct {
+	Executable   string   // installed path of the monitor
+	Config       []string // config file and drop-ins, as listed by config.Files
+	Environment  []string // as listed by config.EnvOverrides
+	Database     string
+	BaselineRoot string // baseline.MerkleRoot of the database
+}
+
+// Mismatch is pinned state that no longer holds
+type Mismatch struct {
+	Kind     string
+	Path     string
+	Expected string // pinned digest, or "absent" for a file that was not pinned
+	Actual   string // current digest, "missing" or the error reading it
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s %s: expected %s, found %s", m.Kind, m.Path, m.Expected, m.Actual)
+}
+
+// EnvironmentDigest is the SHA256 of the overrides, one per line, or empty
+// when there are none
+func EnvironmentDigest(overrides []string) string {
+	if len(overrides) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(overrides, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Pin hashes the files of state into an unsigned manifest
+func Pin(ctx context.Context, state State) (*Manifest, error) {
+	host, _ := os.Hostname()
+	m := &Manifest{
+		Format:       FormatName,
+		Version:      FormatVersion,
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		Host:         host,
+		Environment:  EnvironmentDigest(state.Environment),
+		Database:     state.Database,
+		BaselineRoot: state.BaselineRoot,
+	}
+
+	digest, err := checksum.CalculateSHA256(ctx, state.Executable)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", state.Executable, err)
+	}
+	m.Executable = File{Path: state.Executable, Digest: digest}
+
+	for _, path := range state.Config {
+		digest, err := checksum.CalculateSHA256(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		m.Config = append(m.Config, File{Path: path, Digest: digest})
+	}
+	sort.Slice(m.Config, func(i, j int) bool {
+		return m.Config[i].Path < m.Config[j].Path
+	})
+
+	return m, nil
+}
+
+// signedMessage binds the manifest to its purpose so that a signature made
+// with the same key over a baseline cannot be passed off as one
+func (m *Manifest) signedMessage() []byte {
+	unsigned := *m
+	unsigned.Signature = nil
+	body, _ := json.Marshal(&unsigned)
+	return append([]byte("integrity-monitor self manifest v1\n"), body...)
+}
+
+// Sign attaches an Ed25519 signature over the manifest
+func (m *Manifest) Sign(priv ed25519.PrivateKey) {
+	m.Signature = &baseline.Signature{
+		KeyID: baseline.KeyID(priv.Public().(ed25519.PublicKey)),
+		Value: hex.EncodeToString(ed25519.Sign(priv, m.signedMessage())),
+	}
+}
+
+// Verify checks the format header and the signature against pub
+func (m *Manifest) Verify(pub ed25519.PublicKey) error {
+	if m.Format != FormatName {
+		return fmt.Errorf("not a self-check manifest (format %q)", m.Format)
+	}
+	if m.Version != FormatVersion {
+		return fmt.Errorf("unsupported manifest format version %d", m.Version)
+	}
+	if m.Signature == nil {
+		return errors.New("manifest is not signed")
+	}
+	if m.Signature.KeyID != baseline.KeyID(pub) {
+		return fmt.Errorf("manifest signed with unknown key %s (expected %s)", m.Signature.KeyID, baseline.KeyID(pub))
+	}
+	sig, err := hex.DecodeString(m.Signature.Value)
+	if err != nil || !ed25519.Verify(pub, m.signedMessage(), sig) {
+		return errors.New("manifest signature is invalid")
+	}
+	return nil
+}
+
+// Check compares state, and the image of the running process, with the
+// pinned values. Config files that were not pinned, such as a new drop-in,
+// are mismatches too.
+func (m *Manifest) Check(ctx context.Context, state State) []Mismatch {
+	var mismatches []Mismatch
+	compare := func(kind, path, expected string) {
+		actual, err := checksum.CalculateSHA256(ctx, path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			actual = "missing"
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			actual = err.Error()
+		}
+		if actual != expected {
+			mismatches = append(mismatches, Mismatch{Kind: kind, Path: path, Expected: expected, Actual: actual})
+		}
+	}
+
+	compare(KindExecutable, RunningExecutable, m.Executable.Digest)
+	compare(KindExecutable, m.Executable.Path, m.Executable.Digest)
+
+	pinned := make(map[string]bool, len(m.Config))
+	for _, f := range m.Config {
+		pinned[f.Path] = true
+		compare(KindConfig, f.Path, f.Digest)
+	}
+	for _, path := range state.Config {
+		if !pinned[path] {
+			compare(KindConfig, path, "absent")
+		}
+	}
+
+	if actual := EnvironmentDigest(state.Environment); actual != m.Environment {
+		expected := m.Environment
+		if expected == "" {
+			expected = "no overrides"
+		}
+		if actual == "" {
+			actual = "no overrides"
+		}
+		mismatches = append(mismatches, Mismatch{
+			Kind:     KindEnvironment,
+			Path:     config.EnvPrefix + "*",
+			Expected: expected,
+			Actual:   actual,
+		})
+	}
+
+	if state.BaselineRoot != m.BaselineRoot {
+		mismatches = append(mismatches, Mismatch{
+			Kind:     KindBaseline,
+			Path:     state.Database,
+			Expected: m.BaselineRoot,
+			Actual:   state.BaselineRoot,
+		})
+	}
+
+	return mismatches
+}
+
+// Write stores the manifest as indented JSON
+func Write(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Read loads a manifest; its signature is checked by Verify
+func Read(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &m, nil
+}
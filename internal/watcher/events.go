@@ -1,19 +1,20 @@
 package watcher
 
 import (
+	"context"
 	"log"
-	"os"
 
 	"github.com/fsnotify/fsnotify"
 	"integrity-monitor/internal/checksum"
 	"integrity-monitor/internal/notifier"
+	"integrity-monitor/internal/rootfs"
 )
 
 // CreateFileChangeHandler creates an event handler for file modifications
-func CreateFileChangeHandler(comp *checksum.Comparator, notif notifier.Notifier) EventHandler {
-	return func(path string, event fsnotify.Op) error {
+func CreateFileChangeHandler(comp *checksum.Comparator, notif notifier.Notifier, root rootfs.Root) EventHandler {
+	return func(ctx context.Context, path string, event fsnotify.Op) error {
 		// Check if file is executable
-		info, err := os.Stat(path)
+		info, err := root.Stat(path)
 		if err != nil {
 			return err
 		}
@@ -26,7 +27,7 @@ func CreateFileChangeHandler(comp *checksum.Comparator, notif notifier.Notifier)
 		log.Printf("Detected change in %s (event: %s)", path, event.String())
 
 		// Check the file's integrity
-		alert, err := comp.CheckFile(path)
+		alert, err := comp.CheckFile(ctx, path)
 		if err != nil {
 			return err
 		}
@@ -34,7 +35,7 @@ func CreateFileChangeHandler(comp *checksum.Comparator, notif notifier.Notifier)
 		// If there's an alert, notify users
 		if alert != nil {
 			log.Printf("ALERT: Utility %s has been modified!", path)
-			return notif.SendAlert(alert)
+			return notif.SendAlert(ctx, alert)
 		}
 
 		return nil
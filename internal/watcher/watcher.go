@@ -1,22 +1,44 @@
 package watcher
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"integrity-monitor/internal/health"
+	"integrity-monitor/internal/metrics"
+	"integrity-monitor/internal/rootfs"
 )
 
+// queueSize bounds the events read from inotify but not yet checked; when
+// it is full, reading stops and the kernel queues further events
+const queueSize = 1024
+
+// idleBeat is how often an idle watcher reports that it is alive
+const idleBeat = 10 * time.Second
+
 type Watcher struct {
-	fsWatcher     *fsnotify.Watcher
-	paths         []string
-	eventHandler  EventHandler
+	fsWatcher    *fsnotify.Watcher
+	root         rootfs.Root
+	eventHandler EventHandler
+	mu           sync.Mutex
+	// dirs maps watched host directories to the monitored paths they serve
+	dirs map[string]string
+	// queue decouples hashing from reading events
+	queue     chan fsnotify.Event
+	heartbeat func()
+	closed    atomic.Bool
 }
 
-type EventHandler func(path string, event fsnotify.Op) error
+// EventHandler receives paths of the monitored system, not host paths
+type EventHandler func(ctx context.Context, path string, event fsnotify.Op) error
 
-func NewWatcher(paths []string, handler EventHandler) (*Watcher, error) {
+func NewWatcher(paths []string, root rootfs.Root, handler EventHandler) (*Watcher, error) {
 	fsWatcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create watcher: %w", err)
@@ -24,58 +46,186 @@ func NewWatcher(paths []string, handler EventHandler) (*Watcher, error) {
 
 	w := &Watcher{
 		fsWatcher:    fsWatcher,
-		paths:        paths,
+		root:         root,
 		eventHandler: handler,
+		dirs:         make(map[string]string),
+		queue:        make(chan fsnotify.Event, queueSize),
+		heartbeat:    func() {},
 	}
+	w.SetPaths(paths)
 
-	// Add all paths to watch
+	return w, nil
+}
+
+// SetPaths watches exactly paths, adding and removing watches as needed.
+// Events already queued are still checked.
+func (w *Watcher) SetPaths(paths []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wanted := make(map[string]string, len(paths))
+	var order []string
 	for _, path := range paths {
-		if err := fsWatcher.Add(path); err != nil {
+		hostPath, err := w.root.Resolve(path)
+		if err != nil {
 			log.Printf("Warning: failed to watch %s: %v", path, err)
+			continue
+		}
+		wanted[hostPath] = path
+		order = append(order, hostPath)
+	}
+
+	for hostPath, path := range w.dirs {
+		if _, ok := wanted[hostPath]; ok {
+			continue
+		}
+		if err := w.fsWatcher.Remove(hostPath); err != nil {
+			log.Printf("Warning: failed to stop watching %s: %v", path, err)
 		} else {
+			log.Printf("Stopped watching directory: %s", path)
+		}
+		delete(w.dirs, hostPath)
+	}
+
+	// Add all paths to watch
+	for _, hostPath := range order {
+		path := wanted[hostPath]
+		if _, ok := w.dirs[hostPath]; ok {
+			continue
+		}
+		if err := w.fsWatcher.Add(hostPath); err != nil {
+			log.Printf("Warning: failed to watch %s: %v", path, err)
+		} else {
+			w.dirs[hostPath] = path
 			log.Printf("Watching directory: %s", path)
 		}
 	}
+}
 
-	return w, nil
+// SetHeartbeat sets a function called after each checked event and
+// periodically while idle, so that a hung check can be detected
+func (w *Watcher) SetHeartbeat(beat func()) {
+	w.heartbeat = beat
+}
+
+// Watching returns the number of directories being watched
+func (w *Watcher) Watching() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.dirs)
 }
 
-func (w *Watcher) Start() error {
+// Start reads events until Close is called or ctx is cancelled. It returns
+// once the event being checked is done; on cancellation events still
+// queued are dropped, as the next scan checks those files anyway.
+func (w *Watcher) Start(ctx context.Context) error {
 	log.Println("Starting file watcher...")
 
+	done := make(chan struct{})
+	go func() {
+		w.process(ctx)
+		close(done)
+	}()
+	defer func() {
+		close(w.queue)
+		<-done
+	}()
+	metrics.WatcherQueueDepth.Set(0)
+
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
+
 		case event, ok := <-w.fsWatcher.Events:
 			if !ok {
+				if w.closed.Load() {
+					return nil
+				}
 				return fmt.Errorf("watcher events channel closed")
 			}
 
 			// Only handle write and create events
 			if event.Op&fsnotify.Write == fsnotify.Write ||
 				event.Op&fsnotify.Create == fsnotify.Create {
-				
-				// Get absolute path
-				absPath, err := filepath.Abs(event.Name)
-				if err != nil {
-					log.Printf("Failed to get absolute path for %s: %v", event.Name, err)
-					continue
-				}
-
-				// Call event handler
-				if err := w.eventHandler(absPath, event.Op); err != nil {
-					log.Printf("Error handling event for %s: %v", absPath, err)
+				select {
+				case w.queue <- event:
+				case <-ctx.Done():
+					return nil
 				}
+				metrics.WatcherQueueDepth.Set(float64(len(w.queue)))
 			}
 
 		case err, ok := <-w.fsWatcher.Errors:
 			if !ok {
+				if w.closed.Load() {
+					return nil
+				}
 				return fmt.Errorf("watcher errors channel closed")
 			}
+			metrics.WatcherErrors.Inc()
 			log.Printf("Watcher error: %v", err)
 		}
 	}
 }
 
+// process checks queued events one at a time
+func (w *Watcher) process(ctx context.Context) {
+	ticker := time.NewTicker(idleBeat)
+	defer ticker.Stop()
+
+	// A handler hashing a large file beats while it works
+	ctx = health.WithBeat(ctx, func() { w.heartbeat() })
+
+	for {
+		select {
+		case event, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			w.check(ctx, event)
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		w.heartbeat()
+	}
+}
+
+// check passes one event to the handler as a path of the monitored system
+func (w *Watcher) check(ctx context.Context, event fsnotify.Event) {
+	metrics.WatcherQueueDepth.Set(float64(len(w.queue)))
+	metrics.WatcherEvents.Inc(eventOp(event.Op))
+
+	// Get absolute path
+	absPath, err := filepath.Abs(event.Name)
+	if err != nil {
+		log.Printf("Failed to get absolute path for %s: %v", event.Name, err)
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.dirs[filepath.Dir(absPath)]
+	w.mu.Unlock()
+	if ok {
+		absPath = filepath.Join(dir, filepath.Base(absPath))
+	}
+
+	// Call event handler
+	if err := w.eventHandler(ctx, absPath, event.Op); err != nil && ctx.Err() == nil {
+		log.Printf("Error handling event for %s: %v", absPath, err)
+	}
+}
+
+// eventOp names the operation for metrics; create wins over write
+func eventOp(op fsnotify.Op) string {
+	if op&fsnotify.Create == fsnotify.Create {
+		return "create"
+	}
+	return "write"
+}
+
+// Close stops watching; Start then returns nil
 func (w *Watcher) Close() error {
+	w.closed.Store(true)
 	return w.fsWatcher.Close()
 }
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Delivery states of an outbox entry
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery represents one alert queued for one notification channel
+type Delivery struct {
+	ID            int64      `json:"id"`
+	Alert         *Alert     `json:"alert"`
+	Channel       string     `json:"channel"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// States of a maintenance window
+const (
+	MaintenanceOpen     = "open"
+	MaintenanceApproved = "approved"
+	MaintenanceClosed   = "closed"  // ended without approving its changes
+	MaintenanceExpired  = "expired" // ran out before it was ended
+)
+
+// Kinds of maintenance window
+const (
+	MaintenanceManual   = "manual"   // opened by an operator, see Paths
+	MaintenancePackages = "packages" // package updates awaiting approval into a signed baseline
+)
+
+// MaintenanceWindow is a period during which changes below Paths are
+// queued for approval instead of raising alerts
+type MaintenanceWindow struct {
+	ID        int64      `json:"id"`
+	Kind      string     `json:"kind"`
+	Paths     []string   `json:"paths"`
+	Reason    string     `json:"reason"`
+	StartedBy string     `json:"started_by"`
+	StartedAt time.Time  `json:"started_at"`
+	EndsAt    time.Time  `json:"ends_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+	Status    string     `json:"status"`
+}
+
+// Active reports whether the window is open and not yet expired
+func (w *MaintenanceWindow) Active(now time.Time) bool {
+	return w.Status == MaintenanceOpen && now.Before(w.EndsAt)
+}
+
+// Covers reports whether path is one of the window's paths or below one
+func (w *MaintenanceWindow) Covers(path string) bool {
+	for _, scope := range w.Paths {
+		scope = strings.TrimSuffix(scope, "/")
+		if path == scope || strings.HasPrefix(path, scope+"/") || scope == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceChange is a modification queued in a maintenance window;
+// OldChecksum is the baseline value, NewChecksum the latest one seen
+type MaintenanceChange struct {
+	ID          int64     `json:"id"`
+	WindowID    int64     `json:"window_id"`
+	Path        string    `json:"path"`
+	OldChecksum string    `json:"old_checksum"`
+	NewChecksum string    `json:"new_checksum"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
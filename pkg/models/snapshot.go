@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Snapshot is an immutable copy of the baseline; ID is its generation number
+type Snapshot struct {
+	ID          int64     `json:"id"`
+	Description string    `json:"description"`
+	FileCount   int       `json:"file_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Kinds of baseline changes recorded in history
+const (
+	ChangeAdded    = "added"
+	ChangeUpdated  = "updated"
+	ChangeRemoved  = "removed"
+	ChangeRollback = "rollback"
+)
+
+// HistoryEntry is the baseline value of a file from RecordedAt onwards
+type HistoryEntry struct {
+	ID           int64     `json:"id"`
+	Path         string    `json:"path"`
+	Checksum     string    `json:"checksum"`
+	LastModified time.Time `json:"last_modified"`
+	Size         int64     `json:"size"`
+	Change       string    `json:"change"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+// BaselineSignature is an Ed25519 signature over the Merkle root of the
+// active baseline
+type BaselineSignature struct {
+	ID         int64     `json:"id"`
+	MerkleRoot string    `json:"merkle_root"`
+	Signature  string    `json:"signature"`
+	KeyID      string    `json:"key_id"`
+	FileCount  int       `json:"file_count"`
+	SignedAt   time.Time `json:"signed_at"`
+}
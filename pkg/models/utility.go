@@ -9,15 +9,35 @@ type Utility struct {
 	Checksum     string    `json:"checksum"`
 	LastModified time.Time `json:"last_modified"`
 	Size         int64     `json:"size"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	// Owning package, recorded when package verification is enabled
+	Package        string    `json:"package,omitempty"`
+	PackageVersion string    `json:"package_version,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
-// Alert represents a security alert for a modified utility
+// Kinds of alert
+const (
+	AlertMismatch           = "mismatch"            // UtilityPath no longer has OldChecksum
+	AlertBaselineUntrusted  = "baseline_untrusted"  // the baseline signature does not verify
+	AlertPackageUpdate      = "package_update"      // a mismatch explained by the package manager
+	AlertLockOverdue        = "lock_overdue"        // a package manager lock deferred checks too long
+	AlertMaintenanceExpired = "maintenance_expired" // a maintenance window ran out unapproved
+	AlertSelfCheck          = "self_check"          // the monitor itself differs from its signed manifest
+)
+
+// Alert represents a security alert for a modified utility or, depending
+// on Kind, another event described by Message
 type Alert struct {
-	UtilityPath    string    `json:"utility_path"`
-	OldChecksum    string    `json:"old_checksum"`
-	NewChecksum    string    `json:"new_checksum"`
-	DetectedAt     time.Time `json:"detected_at"`
-	Severity       string    `json:"severity"` // critical, high, medium
+	ID          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	UtilityPath string    `json:"utility_path"`
+	OldChecksum string    `json:"old_checksum,omitempty"`
+	NewChecksum string    `json:"new_checksum,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	DetectedAt  time.Time `json:"detected_at"`
+	Severity    string    `json:"severity"` // critical, high, medium
+	HashVersion int       `json:"hash_version"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"` // SHA256 over PrevHash and the fields above
 }
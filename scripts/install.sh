@@ -18,7 +18,7 @@ fi
 echo "Creating directories..."
 mkdir -p /var/lib/integrity-monitor
 mkdir -p /var/log
-mkdir -p /etc/integrity-monitor
+mkdir -p /etc/integrity-monitor/conf.d
 
 # Copy configuration
 echo "Installing configuration..."
@@ -32,7 +32,7 @@ fi
 # Build the application
 echo "Building application..."
 cd ..
-go build -o integrity-monitor cmd/integrity-monitor/main.go
+go build -o integrity-monitor ./cmd/integrity-monitor
 
 # Install binary
 echo "Installing binary..."
@@ -43,7 +43,8 @@ echo ""
 echo "=== Installation Complete ==="
 echo ""
 echo "Next steps:"
-echo "1. Initialize the database with current system state:"
+echo "1. Create the database schema and record the current system state:"
+echo "   sudo integrity-monitor db migrate"
 echo "   sudo integrity-monitor -init"
 echo ""
 echo "2. Start monitoring:"